@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"github.com/pungle/dawn/logging"
 	"github.com/pungle/dawn/web"
+	"github.com/pungle/dawn/web/pubsub"
+	"net/http"
 	"os"
 	"runtime"
 	"time"
 )
 
-var msgChans map[string]chan string
+var msgHub = pubsub.NewHub(pubsub.HistorySize(100))
 
 func TestIndex(ctx *web.HttpContext) {
 	resp := ctx.Response
@@ -19,11 +21,14 @@ func TestIndex(ctx *web.HttpContext) {
 }
 
 func TestSession(ctx *web.HttpContext) {
-	session := ctx.Session()
+	session, err := ctx.Session()
+	if err == web.ErrDriverUnavailable {
+		http.Error(ctx.Response, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	var count int
 	if session != nil {
-		data, _ := session.Get("count")
-		count = int(data.(float64))
+		count, _ = session.GetInt("count")
 	} else {
 		session = ctx.NewSession()
 	}
@@ -33,36 +38,30 @@ func TestSession(ctx *web.HttpContext) {
 	ctx.Response.Write([]byte(fmt.Sprintf("hello: %d", count)))
 }
 
-func TestReceiveMsg(ctx *web.HttpContext) {
-	req := ctx.Request
-	resp := ctx.Response
-	querys := req.URL.Query()
-	id := querys.Get("id")
-	channel, ok := msgChans[id]
-	if !ok {
-		channel = make(chan string, 10)
-		msgChans[id] = channel
-	}
-	select {
-	case <-time.After(time.Second * 50):
-		resp.Write([]byte("timeout"))
-	case msg := <-channel:
-		resp.Write([]byte(fmt.Sprintf("Get: %s", msg)))
+func TestWebSocket(conn *web.WSConn, ctx *web.HttpContext) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(messageType, data)
 	}
 }
 
+type sendMsgForm struct {
+	ID  string `query:"id"`
+	Msg string `query:"msg" form:"msg"`
+}
+
 func TestSendMsg(ctx *web.HttpContext) {
-	req := ctx.Request
 	resp := ctx.Response
-	querys := req.URL.Query()
-	id := querys.Get("id")
-	msg := querys.Get("msg")
-	channel, ok := msgChans[id]
-	if !ok {
-		channel = make(chan string, 10)
-		msgChans[id] = channel
+	var form sendMsgForm
+	if err := ctx.Bind(&form); err != nil {
+		resp.WriteHeader(400)
+		resp.Write([]byte(err.Error()))
+		return
 	}
-	channel <- msg
+	msgHub.Publish(form.ID, form.Msg)
 	resp.Write([]byte("ok"))
 }
 
@@ -74,7 +73,7 @@ func RegexpUrlTest(ctx *web.HttpContext) {
 
 func main() {
 	runtime.GOMAXPROCS(2)
-	driver := web.NewRedisSessionDriver("tcp", ":6379", 100, 1000, 60*time.Second)
+	driver := web.NewRedisDriver(web.RedisOptions{Addr: ":6379", Prefix: "session:", MaxIdle: 100, MaxActive: 1000, IdleTimeout: 60 * time.Second})
 	sessionCtx := web.NewSessionContext(
 		driver,
 		"sid",      // cookie sessionid
@@ -93,16 +92,23 @@ func main() {
 
 	f, _ := os.OpenFile("/data/logs/access.log", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
 	handler := logging.NewBuffHandler(f, 1024)
-	config := web.NewConfig(":80", web.DEFAULT_LOG_FLAG, web.DEFAULT_LOG_LEVEL, false, "", "")
+	config := web.NewHttpConfig(":80",
+		web.WithReadTimeout(10*time.Second),
+		web.WithIdleTimeout(120*time.Second),
+		web.WithMaxConnections(10000),
+	)
 	server := web.NewServer(config, sessionCtx, handler)
 
 	server.AddHandler("/", TestIndex)
 	server.AddHandler("/counter", TestSession)
-	server.AddHandler("/get", TestReceiveMsg)
+	server.AddHandler("/get", pubsub.LongPollHandler(msgHub, pubsub.QueryTopic("id"), 50*time.Second),
+		web.RouteTimeout(60*time.Second))
 	server.AddHandler("/set", TestSendMsg)
+	server.AddHandler("/stream", pubsub.SSEHandler(msgHub, pubsub.QueryTopic("id"), 15*time.Second),
+		web.RouteTimeout(0))
+	server.WebSocket("/ws", TestWebSocket, web.WSPingInterval(30*time.Second))
 	server.AddHandler("^/test/{id :[0-9]+}$/article/{name: [a-zA-Z]+}$/page/{age: [0-9]{2}}$/", RegexpUrlTest)
 
-	msgChans = make(map[string]chan string)
 	server.ListenAndServe()
 	handler.Flush()
 	f.Close()
@@ -0,0 +1,275 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pungle/dawn/logging"
+	"github.com/pungle/dawn/uuid"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 访问日志格式, 除ACCESS_LOG_JSON外都是Apache风格的格式字符串, 支持的指令有:
+//
+//	%h 客户端地址       %t 请求时间          %r 请求行           %s %>s 状态码
+//	%b 响应字节数(0为-)  %B 响应字节数        %D 耗时(微秒)       %T 耗时(秒)
+//	%I 请求体字节数      %O 响应字节数        %m 请求方法          %U 请求路径
+//	%q 查询字符串        %H 协议             %v Host            %L 请求ID
+//	%R 匹配的路由        %u 用户名           %l 固定为-          %%
+//	%{Header}i 请求头   %{Header}o 响应头
+const (
+	ACCESS_LOG_DEFAULT  = "[%m] %v%U%q %>s %O '%{Content-Type}o' '%h' '%{User-Agent}i' '%{Referer}i' %Dus %L %I %R"
+	ACCESS_LOG_COMMON   = `%h %l %u %t "%r" %>s %b %D %L %I %O %R`
+	ACCESS_LOG_COMBINED = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i" %D %L %I %O %R`
+	ACCESS_LOG_JSON     = "json"
+)
+
+const (
+	REQUEST_ID_HEADER  = "X-Request-ID"
+	ACCESS_TIME_FORMAT = "02/Jan/2006:15:04:05 -0700"
+)
+
+type accessEntry struct {
+	req       *http.Request
+	resp      http.ResponseWriter
+	info      *ResponseInfo
+	pattern   string
+	requestID string
+	bytesIn   int64
+	clientIP  string
+}
+
+type accessFormatter func(buf *bytes.Buffer, entry *accessEntry)
+
+// 请求ID优先使用客户端(或上游代理)传入的X-Request-ID
+func requestID(req *http.Request) string {
+	id := req.Header.Get(REQUEST_ID_HEADER)
+	if id == "" || len(id) > 128 {
+		return uuid.NewUUID().HexString()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return uuid.NewUUID().HexString()
+		}
+	}
+	return id
+}
+
+// 超时的路由中handler可能还在其它goroutine读取body, 计数需要原子操作
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (self *countingBody) Read(p []byte) (int, error) {
+	n, err := self.ReadCloser.Read(p)
+	atomic.AddInt64(&self.n, int64(n))
+	return n, err
+}
+
+func (self *countingBody) count() int64 {
+	return atomic.LoadInt64(&self.n)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func compileAccessFormat(format string) accessFormatter {
+	if format == ACCESS_LOG_JSON {
+		return formatJSONAccess
+	}
+	var parts []accessFormatter
+	literal := func(s string) accessFormatter {
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			buf.WriteString(s)
+		}
+	}
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			parts = append(parts, literal(text.String()))
+			text.Reset()
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i == len(format)-1 {
+			text.WriteByte(c)
+			continue
+		}
+		i++
+		if format[i] == '>' && i < len(format)-1 {
+			i++
+		}
+		var param string
+		if format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 || i+end == len(format)-1 {
+				text.WriteString(format[i-1:])
+				break
+			}
+			param = format[i+1 : i+end]
+			i += end + 1
+		}
+		directive := accessDirective(format[i], param)
+		if directive == nil {
+			text.WriteByte('%')
+			text.WriteByte(format[i])
+			continue
+		}
+		flush()
+		parts = append(parts, directive)
+	}
+	flush()
+	return func(buf *bytes.Buffer, e *accessEntry) {
+		for _, part := range parts {
+			part(buf, e)
+		}
+	}
+}
+
+func accessDirective(c byte, param string) accessFormatter {
+	switch c {
+	case '%':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteByte('%') }
+	case 'h':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.clientIP) }
+	case 'l':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteByte('-') }
+	case 'u':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			user, _, _ := e.req.BasicAuth()
+			buf.WriteString(orDash(user))
+		}
+	case 't':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			buf.WriteByte('[')
+			buf.WriteString(e.info.start.Format(ACCESS_TIME_FORMAT))
+			buf.WriteByte(']')
+		}
+	case 'r':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			buf.WriteString(e.req.Method)
+			buf.WriteByte(' ')
+			buf.WriteString(e.req.RequestURI)
+			buf.WriteByte(' ')
+			buf.WriteString(e.req.Proto)
+		}
+	case 's':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(strconv.Itoa(e.info.Status())) }
+	case 'b':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			if e.info.BytesWritten() == 0 {
+				buf.WriteByte('-')
+				return
+			}
+			buf.WriteString(strconv.FormatInt(e.info.BytesWritten(), 10))
+		}
+	case 'B', 'O':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(strconv.FormatInt(e.info.BytesWritten(), 10)) }
+	case 'I':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(strconv.FormatInt(e.bytesIn, 10)) }
+	case 'D':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			buf.WriteString(strconv.FormatInt(int64(e.info.Duration()/time.Microsecond), 10))
+		}
+	case 'T':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			buf.WriteString(strconv.FormatInt(int64(e.info.Duration()/time.Second), 10))
+		}
+	case 'm':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.req.Method) }
+	case 'U':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.req.URL.Path) }
+	case 'q':
+		return func(buf *bytes.Buffer, e *accessEntry) {
+			if e.req.URL.RawQuery != "" {
+				buf.WriteByte('?')
+				buf.WriteString(e.req.URL.RawQuery)
+			}
+		}
+	case 'H':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.req.Proto) }
+	case 'v':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.req.Host) }
+	case 'L':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.requestID) }
+	case 'R':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(orDash(e.pattern)) }
+	case 'i':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.req.Header.Get(param)) }
+	case 'o':
+		return func(buf *bytes.Buffer, e *accessEntry) { buf.WriteString(e.resp.Header().Get(param)) }
+	}
+	return nil
+}
+
+type jsonAccessLine struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"request_id"`
+	ClientIP  string  `json:"client_ip"`
+	Method    string  `json:"method"`
+	Host      string  `json:"host"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Route     string  `json:"route,omitempty"`
+	Status    int     `json:"status"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+	Latency   float64 `json:"latency_ms"`
+	TTFB      float64 `json:"ttfb_ms"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+func formatJSONAccess(buf *bytes.Buffer, e *accessEntry) {
+	line := &jsonAccessLine{
+		Time:      e.info.start.Format(time.RFC3339Nano),
+		RequestID: e.requestID,
+		ClientIP:  e.clientIP,
+		Method:    e.req.Method,
+		Host:      e.req.Host,
+		URI:       e.req.RequestURI,
+		Proto:     e.req.Proto,
+		Route:     e.pattern,
+		Status:    e.info.Status(),
+		BytesIn:   e.bytesIn,
+		BytesOut:  e.info.BytesWritten(),
+		Latency:   float64(e.info.Duration()) / float64(time.Millisecond),
+		TTFB:      float64(e.info.TimeToFirstByte()) / float64(time.Millisecond),
+		Referer:   e.req.Referer(),
+		UserAgent: e.req.UserAgent(),
+	}
+	data, _ := json.Marshal(line)
+	buf.Write(data)
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// 4xx使用WARN, 5xx使用ERROR, 其他使用INFO, 可以通过访问日志的级别只记录出错的请求
+func accessLevel(status int) int {
+	switch {
+	case status >= 500:
+		return logging.L_ERROR
+	case status >= 400:
+		return logging.L_WARN
+	}
+	return logging.L_INFO
+}
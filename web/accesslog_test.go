@@ -0,0 +1,63 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogFormat(t *testing.T) {
+	req := httptest.NewRequest("POST", "/user/1?x=2", nil)
+	req.Header.Set("User-Agent", "dawn")
+	resp, info := newResponseWriter(httptest.NewRecorder())
+	resp.WriteHeader(404)
+	resp.Write([]byte("hello"))
+	entry := &accessEntry{req: req, resp: resp, info: info, pattern: "^/user/(?P<id>\\d+)$",
+		requestID: "abc", bytesIn: 3, clientIP: "1.2.3.4"}
+
+	var buf bytes.Buffer
+	compileAccessFormat(`%h %m %U%q %>s %b %I %L %R "%{User-Agent}i" 100%% %x`)(&buf, entry)
+	expected := `1.2.3.4 POST /user/1?x=2 404 5 3 abc ^/user/(?P<id>\d+)$ "dawn" 100% %x`
+	if buf.String() != expected {
+		t.Error("Custom format error:", buf.String())
+	}
+
+	buf.Reset()
+	compileAccessFormat(ACCESS_LOG_COMBINED)(&buf, entry)
+	if !strings.HasPrefix(buf.String(), `1.2.3.4 - - [`) || !strings.Contains(buf.String(), `"POST /user/1?x=2 HTTP/1.1" 404 5 "" "dawn"`) {
+		t.Error("Combined format error:", buf.String())
+	}
+
+	buf.Reset()
+	compileAccessFormat(ACCESS_LOG_JSON)(&buf, entry)
+	var line jsonAccessLine
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil || line.Status != 404 || line.BytesOut != 5 ||
+		line.BytesIn != 3 || line.RequestID != "abc" || line.ClientIP != "1.2.3.4" {
+		t.Error("JSON format error:", buf.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	server := newTestServer()
+	var id string
+	server.AddHandler("/", func(ctx *HttpContext) {
+		id = ctx.RequestID()
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	if id == "" || resp.Header().Get(REQUEST_ID_HEADER) != id {
+		t.Error("Generated request id error:", id)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "from-proxy")
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if id != "from-proxy" || resp.Header().Get(REQUEST_ID_HEADER) != "from-proxy" {
+		t.Error("Forwarded request id error:", id)
+	}
+}
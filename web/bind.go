@@ -0,0 +1,235 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrBindTarget = errors.New("BindTargetMustBeStructPointer")
+)
+
+var bindSources = []string{"path", "query", "form", "header"}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type BindError struct {
+	Field  string // 结构体字段名
+	Source string // path, query, form, header
+	Key    string
+	Value  string
+	Err    error
+}
+
+func (self *BindError) Error() string {
+	return fmt.Sprintf("bind %s %q to field %s: invalid value %q: %s",
+		self.Source, self.Key, self.Field, self.Value, self.Err.Error())
+}
+
+func (self *BindError) Unwrap() error {
+	return self.Err
+}
+
+// 根据结构体字段的tag把请求中的参数绑定到v上, v必须为结构体指针, 支持的tag有:
+//
+//	path:"id"  query:"page"  form:"name"  header:"X-Token"
+//
+// 时间类型默认使用RFC3339格式, 可以通过time_format:"2006-01-02"指定.
+// 请求体超过大小限制时直接返回413并返回ErrBodyTooLarge
+func (self *HttpContext) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	form, err := self.bindForm()
+	if err != nil {
+		if err == ErrBodyTooLarge && !self.headerWritten() {
+			self.bodyTooLarge()
+		}
+		return err
+	}
+	return bindStruct(rv.Elem(), func(source string, key string) ([]string, bool) {
+		switch source {
+		case "path":
+			value, ok := self.vars[key]
+			return []string{value}, ok
+		case "query":
+			values, ok := self.Request.URL.Query()[key]
+			return values, ok
+		case "form":
+			values, ok := form[key]
+			return values, ok
+		case "header":
+			values := self.Request.Header.Values(key)
+			return values, len(values) > 0
+		}
+		return nil, false
+	})
+}
+
+func (self *HttpContext) bindForm() (url.Values, error) {
+	req := self.Request
+	if req.Method != "POST" && req.Method != "PUT" && req.Method != "PATCH" {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			return nil, bodyError(err)
+		}
+		return req.PostForm, nil
+	case "multipart/form-data":
+		form, err := self.UploadForm()
+		if err != nil {
+			return nil, err
+		}
+		return form.Value, nil
+	}
+	return nil, nil
+}
+
+type bindLookup func(source string, key string) ([]string, bool)
+
+func bindStruct(rv reflect.Value, lookup bindLookup) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		fv := rv.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindStruct(fv, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		// 未导出的字段, 包括嵌入的未导出非结构体类型
+		if !fv.CanSet() {
+			continue
+		}
+		for _, source := range bindSources {
+			key := field.Tag.Get(source)
+			if key == "" || key == "-" {
+				continue
+			}
+			values, ok := lookup(source, key)
+			if !ok || len(values) == 0 {
+				continue
+			}
+			err := setField(fv, values, field.Tag.Get("time_format"))
+			if err != nil {
+				if numErr, ok := err.(*strconv.NumError); ok {
+					err = numErr.Err
+				}
+				return &BindError{field.Name, source, key, values[0], err}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func setField(fv reflect.Value, values []string, timeFormat string) error {
+	// []byte作为一个值处理
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value, timeFormat); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setValue(fv, values[0], timeFormat)
+}
+
+func setValue(fv reflect.Value, value string, timeFormat string) error {
+	if fv.Kind() == reflect.Ptr {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setValue(ptr.Elem(), value, timeFormat); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) && fv.Type() != timeType {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch fv.Type() {
+	case timeType:
+		if timeFormat == "" {
+			timeFormat = time.RFC3339
+		}
+		t, err := time.Parse(timeFormat, value)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		switch value {
+		case "on":
+			fv.SetBool(true)
+		case "off":
+			fv.SetBool(false)
+		default:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			fv.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		fv.SetBytes([]byte(value))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindPaging struct {
+	Page int  `query:"page"`
+	Size *int `query:"size"`
+}
+
+type bindLevel int
+
+type bindForm struct {
+	bindPaging
+	ID      int64         `path:"id"`
+	Name    string        `form:"name"`
+	Tags    []string      `query:"tag"`
+	Active  bool          `form:"active"`
+	Token   string        `header:"X-Token"`
+	Since   time.Time     `query:"since" time_format:"2006-01-02"`
+	Timeout time.Duration `query:"timeout"`
+	Raw     []byte        `header:"X-Raw"`
+	ignored string        `query:"ignored"`
+}
+
+func TestBind(t *testing.T) {
+	req := httptest.NewRequest("POST",
+		"/user/42?page=3&size=20&tag=a&tag=b&since=2014-07-15&timeout=1m&ignored=x",
+		strings.NewReader("name=pungle&active=on"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("x-token", "secret")
+	req.Header.Set("X-Raw", "bytes")
+	ctx := NewHttpContext(httptest.NewRecorder(), req, nil, map[string]string{"id": "42"})
+
+	var form bindForm
+	if err := ctx.Bind(&form); err != nil {
+		t.Fatal(err)
+	}
+	if form.ID != 42 || form.Page != 3 || form.Size == nil || *form.Size != 20 {
+		t.Error("Bind number error:", form.ID, form.Page, form.Size)
+	}
+	if form.Name != "pungle" || !form.Active || form.Token != "secret" {
+		t.Error("Bind form/header error:", form.Name, form.Active, form.Token)
+	}
+	if len(form.Tags) != 2 || form.Tags[1] != "b" {
+		t.Error("Bind slice error:", form.Tags)
+	}
+	if form.Since.Year() != 2014 || form.Timeout != time.Minute {
+		t.Error("Bind time error:", form.Since, form.Timeout)
+	}
+	if string(form.Raw) != "bytes" {
+		t.Error("Bind []byte error:", form.Raw)
+	}
+	if form.ignored != "" {
+		t.Error("Unexported field should be ignored")
+	}
+
+	// 嵌入的未导出非结构体类型不能设置
+	var level struct {
+		bindLevel `query:"level"`
+	}
+	ctx = NewHttpContext(httptest.NewRecorder(), httptest.NewRequest("GET", "/?level=3", nil), nil, nil)
+	if err := ctx.Bind(&level); err != nil || level.bindLevel != 0 {
+		t.Error("Embedded unexported field should be ignored:", level.bindLevel, err)
+	}
+}
+
+func TestBindError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/list?page=abc", nil)
+	ctx := NewHttpContext(httptest.NewRecorder(), req, nil, nil)
+	var paging bindPaging
+	err := ctx.Bind(&paging)
+	bindErr, ok := err.(*BindError)
+	if !ok {
+		t.Fatal("Bind should return BindError:", err)
+	}
+	if bindErr.Field != "Page" || bindErr.Source != "query" || bindErr.Value != "abc" {
+		t.Error("BindError content error:", bindErr)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Error("BindError should unwrap to the conversion error:", bindErr.Err)
+	}
+	if ctx.Bind(paging) != ErrBindTarget {
+		t.Error("Bind non-pointer should fail")
+	}
+}
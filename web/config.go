@@ -0,0 +1,212 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	DEFAULT_READ_HEADER_TIMEOUT = 10 * time.Second
+	DEFAULT_MAX_HEADER_BYTES    = http.DefaultMaxHeaderBytes
+)
+
+type HttpConfig struct {
+	addr     string
+	tls      bool
+	certfile string
+	keyfile  string
+
+	logFlag      int
+	logLevel     int
+	accessFormat string
+
+	handlerTimeout time.Duration
+	timeoutStatus  int
+
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	maxConns          int
+
+	maxBodySize  int64
+	uploadLimits UploadLimits
+}
+
+type ConfigOption func(*HttpConfig)
+
+func NewHttpConfig(addr string, opts ...ConfigOption) *HttpConfig {
+	config := &HttpConfig{
+		addr:              addr,
+		logFlag:           DEFAULT_LOG_FLAG,
+		logLevel:          DEFAULT_LOG_LEVEL,
+		accessFormat:      ACCESS_LOG_DEFAULT,
+		timeoutStatus:     http.StatusServiceUnavailable,
+		readHeaderTimeout: DEFAULT_READ_HEADER_TIMEOUT,
+		maxHeaderBytes:    DEFAULT_MAX_HEADER_BYTES,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// Deprecated: 使用NewHttpConfig
+func NewConfig(addr string, logFlag int, logLevel int,
+	tls bool, certfile string, keyfile string) *HttpConfig {
+	opts := []ConfigOption{WithLog(logFlag, logLevel)}
+	if tls {
+		opts = append(opts, WithTLS(certfile, keyfile))
+	}
+	return NewHttpConfig(addr, opts...)
+}
+
+func WithLog(logFlag int, logLevel int) ConfigOption {
+	return func(c *HttpConfig) {
+		c.logFlag = logFlag
+		c.logLevel = logLevel
+	}
+}
+
+// 访问日志格式, 可以是ACCESS_LOG_COMMON, ACCESS_LOG_COMBINED, ACCESS_LOG_JSON或者自定义的Apache风格格式
+func WithAccessLogFormat(format string) ConfigOption {
+	return func(c *HttpConfig) {
+		c.accessFormat = format
+	}
+}
+
+func WithTLS(certfile string, keyfile string) ConfigOption {
+	return func(c *HttpConfig) {
+		c.tls = true
+		c.certfile = certfile
+		c.keyfile = keyfile
+	}
+}
+
+// 全局的handler处理超时, 可以通过RouteTimeout为单个路由覆盖
+func WithHandlerTimeout(timeout time.Duration) ConfigOption {
+	return func(c *HttpConfig) {
+		c.handlerTimeout = timeout
+	}
+}
+
+// 超时后返回的状态码, 默认为503
+func WithTimeoutStatus(code int) ConfigOption {
+	return func(c *HttpConfig) {
+		c.timeoutStatus = code
+	}
+}
+
+func WithReadTimeout(timeout time.Duration) ConfigOption {
+	return func(c *HttpConfig) {
+		c.readTimeout = timeout
+	}
+}
+
+func WithReadHeaderTimeout(timeout time.Duration) ConfigOption {
+	return func(c *HttpConfig) {
+		c.readHeaderTimeout = timeout
+	}
+}
+
+func WithWriteTimeout(timeout time.Duration) ConfigOption {
+	return func(c *HttpConfig) {
+		c.writeTimeout = timeout
+	}
+}
+
+func WithIdleTimeout(timeout time.Duration) ConfigOption {
+	return func(c *HttpConfig) {
+		c.idleTimeout = timeout
+	}
+}
+
+func WithMaxHeaderBytes(size int) ConfigOption {
+	return func(c *HttpConfig) {
+		c.maxHeaderBytes = size
+	}
+}
+
+// 限制同时保持的连接数, 超出的连接会在accept前等待, n<=0表示不限制
+func WithMaxConnections(n int) ConfigOption {
+	return func(c *HttpConfig) {
+		c.maxConns = n
+	}
+}
+
+// 全局的请求体最大字节数, 超出时返回413, 可以通过RouteMaxBodySize为单个路由覆盖
+func WithMaxBodySize(size int64) ConfigOption {
+	return func(c *HttpConfig) {
+		c.maxBodySize = size
+	}
+}
+
+// 全局的上传限制, 可以通过RouteUploadLimits为单个路由覆盖
+func WithUploadLimits(limits UploadLimits) ConfigOption {
+	return func(c *HttpConfig) {
+		c.uploadLimits = limits
+	}
+}
+
+func (self *HttpConfig) newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              self.addr,
+		Handler:           handler,
+		ReadTimeout:       self.readTimeout,
+		ReadHeaderTimeout: self.readHeaderTimeout,
+		WriteTimeout:      self.writeTimeout,
+		IdleTimeout:       self.idleTimeout,
+		MaxHeaderBytes:    self.maxHeaderBytes,
+	}
+}
+
+//------------------ limitListener ------------------
+
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, n int) net.Listener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, n), done: make(chan struct{})}
+}
+
+// 连接数已满时在这里等待, Close之后立即返回错误, 否则Serve要等到有连接断开才能退出
+func (self *limitListener) Accept() (net.Conn, error) {
+	select {
+	case self.sem <- struct{}{}:
+	case <-self.done:
+		return nil, net.ErrClosed
+	}
+	conn, err := self.Listener.Accept()
+	if err != nil {
+		<-self.sem
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: func() { <-self.sem }}, nil
+}
+
+func (self *limitListener) Close() error {
+	err := self.Listener.Close()
+	self.closeOnce.Do(func() { close(self.done) })
+	return err
+}
+
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (self *limitConn) Close() error {
+	err := self.Conn.Close()
+	self.once.Do(self.release)
+	return err
+}
@@ -0,0 +1,108 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHttpConfigOptions(t *testing.T) {
+	config := NewHttpConfig(":8080",
+		WithTLS("a.crt", "a.key"),
+		WithReadTimeout(time.Second),
+		WithWriteTimeout(2*time.Second),
+		WithMaxHeaderBytes(4096),
+	)
+	if !config.tls || config.certfile != "a.crt" || config.keyfile != "a.key" {
+		t.Error("TLS option error")
+	}
+	server := config.newServer(nil)
+	if server.ReadTimeout != time.Second || server.WriteTimeout != 2*time.Second {
+		t.Error("Timeout option error", server.ReadTimeout, server.WriteTimeout)
+	}
+	if server.ReadHeaderTimeout != DEFAULT_READ_HEADER_TIMEOUT {
+		t.Error("Default ReadHeaderTimeout error", server.ReadHeaderTimeout)
+	}
+	if server.MaxHeaderBytes != 4096 {
+		t.Error("MaxHeaderBytes option error", server.MaxHeaderBytes)
+	}
+}
+
+func TestLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newLimitListener(l, 1)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("Second connection accepted over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("Second connection was not accepted after release")
+	}
+}
+
+func TestLimitListenerClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newLimitListener(l, 1)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 连接数已满时Close也要让Accept返回
+	result := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	listener.Close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Accept after close should fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept blocked after listener closed")
+	}
+}
@@ -3,7 +3,9 @@
 package web
 
 import (
+	"context"
 	"errors"
+	"github.com/pungle/dawn/logging"
 	"net/http"
 )
 
@@ -15,41 +17,269 @@ type HttpContext struct {
 	Request  *http.Request
 	Response http.ResponseWriter
 	vars     map[string]string
+	info     *ResponseInfo
 
+	requestID string
+	client    *clientInfo
+
+	// 默认的SessionContext, 路由可以通过RouteSession修改
 	sessionCtx *SessionContext
+	sessions   []*sessionState
 
-	curSession Session
+	route         *route
+	body          *limitedBody
+	uploadForm    *UploadForm
+	multipartUsed bool
+	stream        *EventStream
 }
 
 func NewHttpContext(response http.ResponseWriter, request *http.Request,
 	sessionCtx *SessionContext, vars map[string]string) *HttpContext {
-	return &HttpContext{request, response, vars, sessionCtx, nil}
+	var info *ResponseInfo
+	if w, ok := response.(infoWriter); ok {
+		info = w.responseInfo()
+	} else {
+		response, info = newResponseWriter(response)
+	}
+	return &HttpContext{
+		Request:    request,
+		Response:   response,
+		vars:       vars,
+		info:       info,
+		sessionCtx: sessionCtx,
+	}
+}
+
+// 返回当次响应的状态码, 写入字节数等信息
+func (self *HttpContext) ResponseInfo() *ResponseInfo {
+	return self.info
+}
+
+// 当次请求的ID, 取自请求头X-Request-ID, 没有时自动生成, 同时会写入响应头和访问日志
+func (self *HttpContext) RequestID() string {
+	return self.requestID
+}
+
+// 返回当次请求的context, 设置了超时的路由会在超时或客户端断开后被取消
+func (self *HttpContext) Context() context.Context {
+	return self.Request.Context()
 }
 
-func (self *HttpContext) Session() Session {
-	if self.sessionCtx == nil {
+// 当次请求中一个SessionContext对应的session
+type sessionState struct {
+	sessionCtx *SessionContext
+	session    Session
+	err        error
+	saveErr    error // 自动保存失败的原因, 例如ErrSessionConflict
+	loaded     bool
+	watched    bool
+}
+
+// 返回sessionCtx在当次请求中的状态, 同一个SessionContext只会加载一次
+func (self *HttpContext) sessionStateOf(sessionCtx *SessionContext) *sessionState {
+	if sessionCtx == nil {
 		panic(ErrSessionNotSetup)
 	}
-	if self.curSession != nil {
-		return self.curSession
+	for _, state := range self.sessions {
+		if state.sessionCtx == sessionCtx {
+			return state
+		}
 	}
-	self.curSession = self.sessionCtx.Loads(self.Request)
-	return self.curSession
+	state := &sessionState{sessionCtx: sessionCtx}
+	self.sessions = append(self.sessions, state)
+	return state
 }
 
-func (self *HttpContext) NewSession() Session {
-	if self.sessionCtx == nil {
+// 通过HttpServer.AddSessionContext注册的SessionContext, 没有注册时panic(ErrSessionNotSetup)
+func (self *HttpContext) namedSessionCtx(name string) *SessionContext {
+	if self.route == nil {
 		panic(ErrSessionNotSetup)
 	}
-	self.curSession = self.sessionCtx.New()
-	return self.curSession
+	sessionCtx, ok := self.route.server.sessions[name]
+	if !ok {
+		panic(ErrSessionNotSetup)
+	}
+	return sessionCtx
+}
+
+// 返回当前请求的session, 没有可用的session时返回nil和原因, 结果在当次请求中会被缓存.
+// driver读取失败并且使用SESSION_FAIL_CLOSED时会直接响应503
+func (self *HttpContext) Session() (Session, error) {
+	return self.loadSession(self.sessionStateOf(self.sessionCtx))
+}
+
+// 和Session相同, 使用名字为name的SessionContext
+func (self *HttpContext) SessionNamed(name string) (Session, error) {
+	return self.loadSession(self.sessionStateOf(self.namedSessionCtx(name)))
+}
+
+func (self *HttpContext) loadSession(state *sessionState) (Session, error) {
+	if state.loaded {
+		return state.session, state.err
+	}
+	state.session, state.err = state.sessionCtx.Loads(self.Request)
+	state.loaded = true
+	if !state.sessionCtx.replaceable(state.err) && !self.headerWritten() {
+		code := http.StatusServiceUnavailable
+		http.Error(self.Response, http.StatusText(code), code)
+	}
+	self.watchSession(state)
+	return state.session, state.err
+}
+
+func (self *HttpContext) NewSession() Session {
+	return self.newSession(self.sessionStateOf(self.sessionCtx))
+}
+
+// 和NewSession相同, 使用名字为name的SessionContext
+func (self *HttpContext) NewSessionNamed(name string) Session {
+	return self.newSession(self.sessionStateOf(self.namedSessionCtx(name)))
+}
+
+func (self *HttpContext) newSession(state *sessionState) Session {
+	state.session = state.sessionCtx.New()
+	state.err = nil
+	state.loaded = true
+	self.watchSession(state)
+	return state.session
 }
 
 func (self *HttpContext) SaveSession() error {
-	if self.sessionCtx == nil {
-		panic(ErrSessionNotSetup)
+	return self.saveSession(self.sessionStateOf(self.sessionCtx))
+}
+
+// 和SaveSession相同, 使用名字为name的SessionContext
+func (self *HttpContext) SaveSessionNamed(name string) error {
+	return self.saveSession(self.sessionStateOf(self.namedSessionCtx(name)))
+}
+
+func (self *HttpContext) saveSession(state *sessionState) error {
+	return state.sessionCtx.Save(self.Response, state.session)
+}
+
+// 使用session之后, 在写入响应头之前自动保存
+func (self *HttpContext) watchSession(state *sessionState) {
+	if !state.sessionCtx.autoSave || state.watched {
+		return
+	}
+	state.watched = true
+	self.info.onBeforeHeader(func(w http.ResponseWriter) {
+		autoSaveSession(w, state)
+	})
+}
+
+func autoSaveSession(w http.ResponseWriter, state *sessionState) {
+	session, ok := state.session.(*httpSession)
+	if !ok {
+		return
+	}
+	var err error
+	if len(session.data) == 0 {
+		// 数据被删光的session(例如flash消息被取出后)直接删除
+		if session.dirty {
+			err = state.sessionCtx.Destroy(w, session)
+			session.saved()
+		}
+	} else if session.dirty {
+		err = state.sessionCtx.Save(w, session)
+	} else if state.sessionCtx.sliding {
+		err = state.sessionCtx.Touch(w, session)
+	}
+	if err != nil {
+		state.saveErr = err
+	}
+}
+
+// 自动保存session失败的原因, 例如开启版本控制后的ErrSessionConflict, 没有失败时返回nil.
+// 自动保存在写入响应头之前执行, 需要在写入响应之后或handler返回后(例如在中间件中)检查
+func (self *HttpContext) SessionError() error {
+	for _, state := range self.sessions {
+		if state.saveErr != nil {
+			return state.saveErr
+		}
+	}
+	return nil
+}
+
+// handler返回后, 还没有写入响应头时写入响应头以触发自动保存, 否则只把修改保存到driver中
+func (self *HttpContext) finishSession() {
+	wroteHeader, hijacked, timedOut := self.responseState()
+	// 超时后已经返回了超时响应, handler对session的修改不再保存
+	if hijacked || timedOut {
+		return
+	}
+	for _, state := range self.sessions {
+		if !state.watched || state.session == nil {
+			continue
+		}
+		if !wroteHeader {
+			self.Response.WriteHeader(http.StatusOK)
+			return
+		}
+		session, ok := state.session.(*httpSession)
+		if !ok || !session.dirty {
+			continue
+		}
+		// cookie没有发出的session客户端无法使用, 保存到driver中只会留下无用的数据
+		if !session.delivered {
+			logging.Warn("Session created after response header written, changes are lost.")
+			continue
+		}
+		if err := state.sessionCtx.store(session); err != nil {
+			state.saveErr = err
+		}
+	}
+}
+
+// 删除当前的session(包括driver中的数据和cookie), 用于注销
+func (self *HttpContext) DestroySession() error {
+	return self.destroySession(self.sessionStateOf(self.sessionCtx))
+}
+
+// 和DestroySession相同, 使用名字为name的SessionContext
+func (self *HttpContext) DestroySessionNamed(name string) error {
+	return self.destroySession(self.sessionStateOf(self.namedSessionCtx(name)))
+}
+
+func (self *HttpContext) destroySession(state *sessionState) error {
+	session, _ := self.loadSession(state)
+	state.session, state.err = nil, ErrNoSession
+	return state.sessionCtx.Destroy(self.Response, session)
+}
+
+// 更换当前session的ID并保留数据, 没有session时创建一个新的session(需要自己保存).
+// driver读取失败并且使用SESSION_FAIL_CLOSED时返回ErrDriverUnavailable
+func (self *HttpContext) RegenerateSession() (Session, error) {
+	return self.regenerateSession(self.sessionStateOf(self.sessionCtx))
+}
+
+// 和RegenerateSession相同, 使用名字为name的SessionContext
+func (self *HttpContext) RegenerateSessionNamed(name string) (Session, error) {
+	return self.regenerateSession(self.sessionStateOf(self.namedSessionCtx(name)))
+}
+
+func (self *HttpContext) regenerateSession(state *sessionState) (Session, error) {
+	session, err := self.loadSession(state)
+	if session == nil {
+		if !state.sessionCtx.replaceable(err) {
+			return nil, err
+		}
+		return self.newSession(state), nil
+	}
+	session, err = state.sessionCtx.Regenerate(self.Response, session)
+	if err != nil {
+		return nil, err
+	}
+	state.session = session
+	return session, nil
+}
+
+// handler返回后释放请求占用的资源
+func (self *HttpContext) cleanup() {
+	if self.stream != nil {
+		self.stream.Close()
 	}
-	return self.sessionCtx.Save(self.Response, self.curSession)
+	self.cleanupUpload()
 }
 
 func (self *HttpContext) GetVar(key string) string {
@@ -0,0 +1,226 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/pungle/dawn/logging"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_COOKIE_SIZE = 4096
+	cookieChunkFlag = "~"
+)
+
+var (
+	ErrInvalidCookieKey = errors.New("InvalidCookieKey")
+	ErrCookieTooLarge   = errors.New("CookieTooLarge")
+	ErrCookieDecrypt    = errors.New("CookieDecryptFailed")
+)
+
+type CookieStoreOption func(*CookieStore)
+
+// 数据超过单个cookie的4KB限制时最多拆分成n个cookie, 默认不拆分, 超出时返回ErrCookieTooLarge
+func CookieChunks(n int) CookieStoreOption {
+	return func(s *CookieStore) {
+		s.maxChunks = n
+	}
+}
+
+// 把整个session使用AES-GCM加密后保存在cookie中, 服务端不需要driver.
+// keys中的第一个用于加密, 所有的key都可以用于解密, 轮换key时把新key放在最前面即可.
+// key的长度必须是16, 24或32字节
+type CookieStore struct {
+	aeads     []cipher.AEAD
+	maxChunks int
+}
+
+func NewCookieStore(keys [][]byte, opts ...CookieStoreOption) (*CookieStore, error) {
+	if len(keys) == 0 {
+		return nil, ErrInvalidCookieKey
+	}
+	store := &CookieStore{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, ErrInvalidCookieKey
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, ErrInvalidCookieKey
+		}
+		store.aeads = append(store.aeads, aead)
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	return store, nil
+}
+
+// 使用cookie保存session的SessionContext, 参数的含义和NewSessionContext相同,
+// sessionAge为session的有效期, 写在加密的数据中
+func NewCookieSessionContext(store *CookieStore, cookieName string,
+	cookieDomain string, cookieExpire time.Duration,
+	cookiePath string, cookieHttpOnly bool,
+	cookieSecure bool, sessionAge time.Duration) *SessionContext {
+
+	ctx := NewSessionContext(nil, cookieName, cookieDomain, cookieExpire,
+		cookiePath, cookieHttpOnly, cookieSecure, sessionAge)
+	ctx.cookieStore = store
+	return ctx
+}
+
+// cookie名字作为附加数据参与认证, 加密后的值不能挪到别的cookie中使用
+func (self *CookieStore) encrypt(name string, plain []byte) (string, error) {
+	aead := self.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (self *CookieStore) decrypt(name string, value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrCookieDecrypt
+	}
+	for _, aead := range self.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, cipherText := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, cipherText, []byte(name))
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrCookieDecrypt
+}
+
+func chunkName(name string, idx int) string {
+	return name + "_" + strconv.Itoa(idx)
+}
+
+// 读取cookie的值, 拆分保存时主cookie的值为"~n", 数据保存在name_0到name_n-1中
+func (self *CookieStore) readValue(req *http.Request, name string) (string, int) {
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return "", 0
+	}
+	if !strings.HasPrefix(cookie.Value, cookieChunkFlag) {
+		return cookie.Value, 0
+	}
+	n, err := strconv.Atoi(cookie.Value[len(cookieChunkFlag):])
+	if err != nil || n <= 0 || n > self.maxChunks {
+		return "", 0
+	}
+	var value strings.Builder
+	for idx := 0; idx < n; idx++ {
+		chunk, err := req.Cookie(chunkName(name, idx))
+		if err != nil {
+			return "", n
+		}
+		value.WriteString(chunk.Value)
+	}
+	return value.String(), n
+}
+
+func (self *SessionContext) loadCookie(req *http.Request) (Session, error) {
+	store := self.cookieStore
+	value, chunks := store.readValue(req, self.cookieName)
+	if value == "" {
+		return nil, ErrNoSession
+	}
+	plain, err := store.decrypt(self.cookieName, value)
+	if err != nil {
+		logging.Warn("LoadSession error: %s", err.Error())
+		return nil, ErrInvalidSession
+	}
+	sid, expires, content, err := parseCookiePayload(plain)
+	if err != nil {
+		logging.Warn("LoadSession error: %s", err.Error())
+		return nil, ErrInvalidSession
+	}
+	if expires > 0 && time.Now().Unix() > expires {
+		return nil, ErrSessionExpired
+	}
+	data, err := self.decode(content)
+	if err != nil {
+		logging.Warn("LoadSession error: %s", err.Error())
+		return nil, ErrInvalidSession
+	}
+	return &httpSession{sid: sid, data: data, chunks: chunks, delivered: true}, nil
+}
+
+// 加密前的数据: 8字节过期时间(unix秒, 0表示不过期) + 2字节session ID长度 + session ID + 编码后的session数据
+func cookiePayload(sid string, expires int64, data []byte) []byte {
+	payload := make([]byte, 10, 10+len(sid)+len(data))
+	binary.BigEndian.PutUint64(payload, uint64(expires))
+	binary.BigEndian.PutUint16(payload[8:], uint16(len(sid)))
+	payload = append(payload, sid...)
+	return append(payload, data...)
+}
+
+func parseCookiePayload(payload []byte) (string, int64, []byte, error) {
+	if len(payload) < 10 {
+		return "", 0, nil, ErrCookieDecrypt
+	}
+	expires := int64(binary.BigEndian.Uint64(payload))
+	size := int(binary.BigEndian.Uint16(payload[8:]))
+	if len(payload) < 10+size {
+		return "", 0, nil, ErrCookieDecrypt
+	}
+	return string(payload[10 : 10+size]), expires, payload[10+size:], nil
+}
+
+func (self *SessionContext) saveCookie(resp http.ResponseWriter, sid string, data []byte, chunks int) error {
+	store := self.cookieStore
+	var expires int64
+	if self.sessionAge > 0 {
+		expires = time.Now().Add(self.sessionAge).Unix()
+	}
+	value, err := store.encrypt(self.cookieName, cookiePayload(sid, expires, data))
+	if err != nil {
+		return err
+	}
+
+	cookies := []*http.Cookie{self.newCookie(self.cookieName, value)}
+	if len(cookies[0].String()) > MAX_COOKIE_SIZE {
+		// 预留chunk名字和属性的长度
+		size := MAX_COOKIE_SIZE - len(self.newCookie(chunkName(self.cookieName, store.maxChunks), "").String())
+		if store.maxChunks <= 0 || size <= 0 {
+			return ErrCookieTooLarge
+		}
+		n := (len(value) + size - 1) / size
+		if n > store.maxChunks {
+			return ErrCookieTooLarge
+		}
+		cookies = []*http.Cookie{self.newCookie(self.cookieName, cookieChunkFlag+strconv.Itoa(n))}
+		for idx := 0; idx < n; idx++ {
+			end := (idx + 1) * size
+			if end > len(value) {
+				end = len(value)
+			}
+			cookies = append(cookies, self.newCookie(chunkName(self.cookieName, idx), value[idx*size:end]))
+		}
+	}
+	for _, cookie := range cookies {
+		http.SetCookie(resp, cookie)
+	}
+	// 清理上次拆分时多出来的cookie
+	for idx := len(cookies) - 1; idx < chunks; idx++ {
+		self.expireCookie(resp, chunkName(self.cookieName, idx))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 修改第一个字符, 保证和原来的值不同
+func tamper(value string) string {
+	if value[0] == 'A' {
+		return "B" + value[1:]
+	}
+	return "A" + value[1:]
+}
+
+func cookieRequest(resp *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			req.AddCookie(cookie)
+		}
+	}
+	return req
+}
+
+func TestCookieStore(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 16)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	if _, err := NewCookieStore([][]byte{[]byte("short")}); err != ErrInvalidCookieKey {
+		t.Error("Invalid key error:", err)
+	}
+	store, _ := NewCookieStore([][]byte{oldKey})
+	sessionCtx := NewCookieSessionContext(store, "sid", "", time.Hour, "/", true, false, time.Hour)
+
+	session := sessionCtx.New()
+	session.Set("name", "dawn")
+	resp := httptest.NewRecorder()
+	if err := sessionCtx.Save(resp, session); err != nil {
+		t.Fatal("Save error:", err)
+	}
+	req := cookieRequest(resp)
+	loaded, err := sessionCtx.Loads(req)
+	if loaded == nil || err != nil {
+		t.Fatal("Session should be loaded from cookie")
+	}
+	name, _ := loaded.Get("name")
+	sid, _ := session.ID()
+	loadedSid, _ := loaded.ID()
+	if name != "dawn" || sid != loadedSid {
+		t.Error("Cookie session error:", name, loadedSid)
+	}
+
+	// 新key加密, 旧key仍然可以解密
+	rotated, _ := NewCookieStore([][]byte{newKey, oldKey})
+	sessionCtx.cookieStore = rotated
+	if loaded, _ := sessionCtx.Loads(req); loaded == nil {
+		t.Error("Rotated store should decrypt cookie of old key")
+	}
+	sessionCtx.cookieStore, _ = NewCookieStore([][]byte{newKey})
+	if _, err := sessionCtx.Loads(req); err != ErrInvalidSession {
+		t.Error("Unknown key should not decrypt cookie:", err)
+	}
+
+	tampered := httptest.NewRequest("GET", "/", nil)
+	cookie, _ := req.Cookie("sid")
+	tampered.AddCookie(&http.Cookie{Name: "sid", Value: tamper(cookie.Value)})
+	if _, err := sessionCtx.Loads(tampered); err != ErrInvalidSession {
+		t.Error("Tampered cookie should be rejected:", err)
+	}
+
+	expired := NewCookieSessionContext(store, "sid", "", time.Hour, "/", true, false, time.Nanosecond)
+	resp = httptest.NewRecorder()
+	expired.Save(resp, session)
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := expired.Loads(cookieRequest(resp)); err != ErrSessionExpired {
+		t.Error("Expired cookie session should not be loaded:", err)
+	}
+}
+
+func TestCookieStoreChunks(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 16)
+	store, _ := NewCookieStore([][]byte{key})
+	sessionCtx := NewCookieSessionContext(store, "sid", "", time.Hour, "/", true, false, time.Hour)
+	session := sessionCtx.New()
+	session.Set("big", strings.Repeat("x", 6000))
+	if err := sessionCtx.Save(httptest.NewRecorder(), session); err != ErrCookieTooLarge {
+		t.Error("Large session should be rejected without chunks:", err)
+	}
+
+	store.maxChunks = 3
+	resp := httptest.NewRecorder()
+	if err := sessionCtx.Save(resp, session); err != nil {
+		t.Fatal("Chunked save error:", err)
+	}
+	for _, cookie := range resp.Result().Cookies() {
+		if len(cookie.String()) > MAX_COOKIE_SIZE {
+			t.Error("Cookie exceeds size limit:", cookie.Name, len(cookie.String()))
+		}
+	}
+	chunks := len(resp.Result().Cookies()) - 1
+	loaded, _ := sessionCtx.Loads(cookieRequest(resp))
+	if loaded == nil || chunks < 2 {
+		t.Fatal("Chunked session should be loaded:", chunks)
+	}
+	if value, _ := loaded.Get("big"); value != strings.Repeat("x", 6000) {
+		t.Error("Chunked value error")
+	}
+
+	loaded.Set("big", "small")
+	resp = httptest.NewRecorder()
+	sessionCtx.Save(resp, loaded)
+	var removed int
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			removed++
+		}
+	}
+	if removed != chunks {
+		t.Error("Stale chunk cookies should be removed:", removed)
+	}
+}
@@ -0,0 +1,245 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SECURE_COOKIE_PREFIX = "__Secure-"
+	HOST_COOKIE_PREFIX   = "__Host-"
+)
+
+var (
+	ErrInsecureCookie      = errors.New("InsecureCookie")
+	ErrInvalidCookiePrefix = errors.New("InvalidCookiePrefix")
+	ErrInvalidCookie       = errors.New("InvalidCookie")
+	ErrCookieKeyNotSet     = errors.New("CookieKeyNotSet")
+)
+
+// 浏览器会拒绝的cookie: SameSite=None, Partitioned和__Secure-前缀需要Secure,
+// __Host-前缀还要求Path为/并且不能设置Domain
+func checkCookie(cookie *http.Cookie) error {
+	if strings.HasPrefix(cookie.Name, HOST_COOKIE_PREFIX) {
+		if !cookie.Secure || cookie.Path != "/" || cookie.Domain != "" {
+			return ErrInvalidCookiePrefix
+		}
+	}
+	if !cookie.Secure && (cookie.SameSite == http.SameSiteNoneMode || cookie.Partitioned ||
+		strings.HasPrefix(cookie.Name, SECURE_COOKIE_PREFIX)) {
+		return ErrInsecureCookie
+	}
+	return nil
+}
+
+// 开启ctx.SetCookie的CookieSigned选项, 第一个key用于签名, 其余的只用于验证.
+// key的长度要求和SessionContext.SetIDSigningKeys相同, 太短时返回ErrInvalidSessionIDKey
+func (self *HttpServer) SetCookieSigningKeys(keys ...[]byte) error {
+	if len(keys) == 0 {
+		self.cookieSigner = nil
+		return nil
+	}
+	signer, err := newHMACSigner(keys)
+	if err != nil {
+		return err
+	}
+	self.cookieSigner = signer
+	return nil
+}
+
+// 开启ctx.SetCookie的CookieEncrypted选项, key的要求和NewCookieStore相同
+func (self *HttpServer) SetCookieEncryptionKeys(keys ...[]byte) error {
+	if len(keys) == 0 {
+		self.cookieCipher = nil
+		return nil
+	}
+	store, err := NewCookieStore(keys)
+	if err != nil {
+		return err
+	}
+	self.cookieCipher = store
+	return nil
+}
+
+type CookieOption func(*cookieOptions)
+
+type cookieOptions struct {
+	cookie    http.Cookie
+	signed    bool
+	encrypted bool
+}
+
+func CookiePath(path string) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.Path = path
+	}
+}
+
+func CookieDomain(domain string) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.Domain = domain
+	}
+}
+
+// cookie的有效期, 默认为0(浏览器关闭时删除)
+func CookieMaxAge(maxAge time.Duration) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.MaxAge = int(maxAge / time.Second)
+		o.cookie.Expires = time.Now().Add(maxAge)
+	}
+}
+
+// 默认为true
+func CookieHttpOnly(httpOnly bool) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.HttpOnly = httpOnly
+	}
+}
+
+// 默认在请求为https时为true
+func CookieSecure(secure bool) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.Secure = secure
+	}
+}
+
+// 默认为http.SameSiteLaxMode
+func CookieSameSite(mode http.SameSite) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.SameSite = mode
+	}
+}
+
+func CookiePartitioned(partitioned bool) CookieOption {
+	return func(o *cookieOptions) {
+		o.cookie.Partitioned = partitioned
+	}
+}
+
+// 使用HMAC签名, 客户端可以看到但不能修改cookie的值, 需要先调用server.SetCookieSigningKeys
+func CookieSigned() CookieOption {
+	return func(o *cookieOptions) {
+		o.signed = true
+	}
+}
+
+// 使用AES-GCM加密, 加密已经包含了认证, 不需要再签名. 需要先调用server.SetCookieEncryptionKeys
+func CookieEncrypted() CookieOption {
+	return func(o *cookieOptions) {
+		o.encrypted = true
+	}
+}
+
+func (self *HttpContext) cookieOptions(name string, opts []CookieOption) *cookieOptions {
+	options := &cookieOptions{cookie: http.Cookie{
+		Name:     name,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   self.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+func (self *HttpContext) cookieSigner() *hmacSigner {
+	if self.route == nil {
+		return nil
+	}
+	return self.route.server.cookieSigner
+}
+
+func (self *HttpContext) cookieCipher() *CookieStore {
+	if self.route == nil {
+		return nil
+	}
+	return self.route.server.cookieCipher
+}
+
+// 写入cookie, 签名或加密时原始值可以是任意字符串
+func (self *HttpContext) SetCookie(name string, value string, opts ...CookieOption) error {
+	options := self.cookieOptions(name, opts)
+	cookie := &options.cookie
+	if err := checkCookie(cookie); err != nil {
+		return err
+	}
+	switch {
+	case options.encrypted:
+		cipher := self.cookieCipher()
+		if cipher == nil {
+			return ErrCookieKeyNotSet
+		}
+		encrypted, err := cipher.encrypt(name, []byte(value))
+		if err != nil {
+			return err
+		}
+		cookie.Value = encrypted
+	case options.signed:
+		signer := self.cookieSigner()
+		if signer == nil {
+			return ErrCookieKeyNotSet
+		}
+		cookie.Value = signer.sign(name+"=", base64.RawURLEncoding.EncodeToString([]byte(value)))
+	default:
+		cookie.Value = value
+	}
+	if len(cookie.String()) > MAX_COOKIE_SIZE {
+		return ErrCookieTooLarge
+	}
+	http.SetCookie(self.Response, cookie)
+	return nil
+}
+
+// 读取cookie, 只需要传入和SetCookie相同的CookieSigned或CookieEncrypted选项.
+// 不存在时返回http.ErrNoCookie, 签名错误或无法解密时返回ErrInvalidCookie
+func (self *HttpContext) Cookie(name string, opts ...CookieOption) (string, error) {
+	options := self.cookieOptions(name, opts)
+	cookie, err := self.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case options.encrypted:
+		cipher := self.cookieCipher()
+		if cipher == nil {
+			return "", ErrCookieKeyNotSet
+		}
+		plain, err := cipher.decrypt(name, cookie.Value)
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		return string(plain), nil
+	case options.signed:
+		signer := self.cookieSigner()
+		if signer == nil {
+			return "", ErrCookieKeyNotSet
+		}
+		value, ok := signer.verify(name+"=", cookie.Value)
+		if !ok {
+			return "", ErrInvalidCookie
+		}
+		plain, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		return string(plain), nil
+	}
+	return cookie.Value, nil
+}
+
+// 让浏览器删除cookie, Path, Domain和Partitioned需要和写入时相同
+func (self *HttpContext) DeleteCookie(name string, opts ...CookieOption) {
+	options := self.cookieOptions(name, opts)
+	cookie := &options.cookie
+	cookie.Value = ""
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	http.SetCookie(self.Response, cookie)
+}
@@ -0,0 +1,122 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCookieAttributes(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	session := sessionCtx.New()
+	session.Set("user", "dawn")
+	resp := httptest.NewRecorder()
+	sessionCtx.Save(resp, session)
+	if cookie := sessionCookie(resp); cookie == nil || cookie.SameSite != http.SameSiteLaxMode {
+		t.Error("Session cookie should default to SameSite=Lax:", cookie)
+	}
+
+	sessionCtx.SetSameSite(http.SameSiteNoneMode)
+	if err := sessionCtx.Save(httptest.NewRecorder(), session); err != ErrInsecureCookie {
+		t.Error("SameSite=None without secure should be rejected:", err)
+	}
+	sessionCtx.cookieSecure = true
+	sessionCtx.SetPartitioned(true)
+	resp = httptest.NewRecorder()
+	if err := sessionCtx.Save(resp, session); err != nil {
+		t.Fatal("Save error:", err)
+	}
+	header := resp.Header().Get("Set-Cookie")
+	if !strings.Contains(header, "SameSite=None") || !strings.Contains(header, "Partitioned") {
+		t.Error("Cookie attributes error:", header)
+	}
+
+	for name, expected := range map[string]error{
+		"__Host-sid":   ErrInvalidCookiePrefix,
+		"__Secure-sid": nil,
+	} {
+		prefixed := NewSessionContext(driver, name, "test.com", time.Hour, "/", true, true, time.Hour)
+		if err := prefixed.Validate(); err != expected {
+			t.Error("Prefix rule error:", name, err)
+		}
+	}
+	if NewSessionContext(driver, "__Secure-sid", "", time.Hour, "/", true, false, time.Hour).Validate() != ErrInsecureCookie {
+		t.Error("__Secure- cookie requires secure")
+	}
+	if NewSessionContext(driver, "__Host-sid", "", time.Hour, "/", true, true, time.Hour).Validate() != nil {
+		t.Error("Valid __Host- cookie should pass")
+	}
+}
+
+func TestCookieHelpers(t *testing.T) {
+	server := NewServer(NewHttpConfig(":0"), nil, &discardHandler{})
+	server.SetCookieSigningKeys(bytes.Repeat([]byte("s"), 32))
+	server.SetCookieEncryptionKeys(bytes.Repeat([]byte("e"), 32))
+	var values []string
+	var errs []error
+	server.AddHandler("/set", func(ctx *HttpContext) {
+		errs = append(errs,
+			ctx.SetCookie("plain", "value", CookieMaxAge(time.Hour)),
+			ctx.SetCookie("signed", "user=dawn; 1", CookieSigned()),
+			ctx.SetCookie("secret", "token", CookieEncrypted()),
+			ctx.SetCookie("__Host-bad", "x", CookieDomain("test.com")))
+		ctx.DeleteCookie("old")
+	})
+	server.AddHandler("/get", func(ctx *HttpContext) {
+		values = values[:0]
+		errs = errs[:0]
+		for _, read := range []func() (string, error){
+			func() (string, error) { return ctx.Cookie("plain") },
+			func() (string, error) { return ctx.Cookie("signed", CookieSigned()) },
+			func() (string, error) { return ctx.Cookie("secret", CookieEncrypted()) },
+			func() (string, error) { return ctx.Cookie("none") },
+		} {
+			value, err := read()
+			values = append(values, value)
+			errs = append(errs, err)
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "https://test.com/set", nil))
+	if errs[0] != nil || errs[1] != nil || errs[2] != nil || errs[3] != ErrInvalidCookiePrefix {
+		t.Fatal("SetCookie error:", errs)
+	}
+	cookies := resp.Result().Cookies()
+	if len(cookies) != 4 || !cookies[0].Secure || !cookies[0].HttpOnly || cookies[0].MaxAge != 3600 ||
+		cookies[0].SameSite != http.SameSiteLaxMode || cookies[3].Name != "old" || cookies[3].MaxAge >= 0 {
+		t.Fatal("Cookie attributes error:", cookies)
+	}
+	if strings.Contains(cookies[2].Value, "token") {
+		t.Error("Encrypted cookie should not contain plain value")
+	}
+
+	req := httptest.NewRequest("GET", "/get", nil)
+	for _, cookie := range cookies[:3] {
+		req.AddCookie(cookie)
+	}
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	if values[0] != "value" || values[1] != "user=dawn; 1" || values[2] != "token" || errs[3] != http.ErrNoCookie {
+		t.Error("Cookie error:", values, errs)
+	}
+
+	// 篡改的值和挪到其他名字下的签名都会被拒绝
+	req = httptest.NewRequest("GET", "/get", nil)
+	req.AddCookie(&http.Cookie{Name: "signed", Value: tamper(cookies[1].Value)})
+	req.AddCookie(&http.Cookie{Name: "secret", Value: cookies[1].Value})
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	if errs[1] != ErrInvalidCookie || errs[2] != ErrInvalidCookie {
+		t.Error("Tampered cookie should be rejected:", errs)
+	}
+
+	ctx := NewHttpContext(httptest.NewRecorder(), req, nil, nil)
+	if ctx.SetCookie("signed", "x", CookieSigned()) != ErrCookieKeyNotSet {
+		t.Error("Signing without key should fail")
+	}
+}
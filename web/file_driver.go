@@ -0,0 +1,281 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	DEFAULT_FILE_GC_INTERVAL = 10 * time.Minute
+)
+
+var (
+	ErrBadSessionFile = errors.New("BadSessionFile")
+)
+
+const (
+	FILE_SESSION_MAGIC = "DWS1"
+	fileHeaderSize     = 12
+	fileTempPrefix     = ".tmp-"
+)
+
+type FileDriverOption func(*FileDriver)
+
+// 清理过期session文件的间隔, interval<=0表示不启动清理的goroutine
+func FileGCInterval(interval time.Duration) FileDriverOption {
+	return func(d *FileDriver) {
+		d.gcInterval = interval
+	}
+}
+
+// 每个session保存为一个文件, 文件名为session ID的sha1, 按前两位分到不同的子目录.
+// 文件头为4字节的FILE_SESSION_MAGIC加8字节的过期时间(unix纳秒, 0表示不过期), 之后是session数据
+type FileDriver struct {
+	dir        string
+	gcInterval time.Duration
+
+	// 每个子目录一个锁, 避免删除过期文件或Touch时覆盖并发Set写入的新文件
+	locks [256]sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewFileSessionDriver(dir string, opts ...FileDriverOption) (*FileDriver, error) {
+	driver := &FileDriver{
+		dir:        dir,
+		gcInterval: DEFAULT_FILE_GC_INTERVAL,
+		stop:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(driver)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if driver.gcInterval > 0 {
+		go driver.sweeper()
+	}
+	return driver, nil
+}
+
+func (self *FileDriver) path(key string) (string, string) {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	shard := filepath.Join(self.dir, name[:2])
+	return shard, filepath.Join(shard, name)
+}
+
+// shard为子目录, 目录名是文件名sha1的前两位
+func (self *FileDriver) lock(shard string) *sync.Mutex {
+	idx, _ := strconv.ParseUint(filepath.Base(shard), 16, 8)
+	return &self.locks[idx]
+}
+
+// 加锁后重新检查, 文件在这期间被Set更新过时不删除
+func (self *FileDriver) removeExpired(shard string, path string) {
+	lock := self.lock(shard)
+	lock.Lock()
+	defer lock.Unlock()
+	expires, err := readFileExpires(path)
+	if err == nil && !expires.IsZero() && time.Now().After(expires) {
+		os.Remove(path)
+	}
+}
+
+func fileExpires(expire time.Duration) int64 {
+	if expire <= 0 {
+		return 0
+	}
+	return time.Now().Add(expire).UnixNano()
+}
+
+// key不存在或已过期时返回nil
+func (self *FileDriver) Get(key string) ([]byte, error) {
+	shard, path := self.path(key)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	expires, err := parseFileHeader(content)
+	if err != nil {
+		return nil, err
+	}
+	if !expires.IsZero() && time.Now().After(expires) {
+		self.removeExpired(shard, path)
+		return nil, nil
+	}
+	return content[fileHeaderSize:], nil
+}
+
+// 先写入同目录下的临时文件并fsync, 再rename覆盖, 读取时不会看到写了一半的文件
+func (self *FileDriver) Set(key string, value []byte, expire time.Duration) error {
+	var buf bytes.Buffer
+	buf.WriteString(FILE_SESSION_MAGIC)
+	binary.Write(&buf, binary.BigEndian, fileExpires(expire))
+	buf.Write(value)
+
+	shard, path := self.path(key)
+	lock := self.lock(shard)
+	lock.Lock()
+	defer lock.Unlock()
+	return self.write(shard, path, buf.Bytes())
+}
+
+// 调用者需要持有shard的锁
+func (self *FileDriver) write(shard string, path string, content []byte) error {
+	if err := os.MkdirAll(shard, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(shard, fileTempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(shard)
+}
+
+func (self *FileDriver) Delete(key string) error {
+	_, path := self.path(key)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 在锁内读取并通过临时文件重写, 不会覆盖并发Set写入的数据
+func (self *FileDriver) Touch(key string, expire time.Duration) error {
+	shard, path := self.path(key)
+	lock := self.lock(shard)
+	lock.Lock()
+	defer lock.Unlock()
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	expires, err := parseFileHeader(content)
+	if err != nil {
+		return err
+	}
+	if !expires.IsZero() && time.Now().After(expires) {
+		return nil
+	}
+	binary.BigEndian.PutUint64(content[4:fileHeaderSize], uint64(fileExpires(expire)))
+	return self.write(shard, path, content)
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// 部分文件系统不支持对目录fsync, 会返回EINVAL, 这时只能依赖文件本身的Sync
+	if err := f.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
+func parseFileHeader(content []byte) (time.Time, error) {
+	if len(content) < fileHeaderSize || string(content[:4]) != FILE_SESSION_MAGIC {
+		return time.Time{}, ErrBadSessionFile
+	}
+	expires := int64(binary.BigEndian.Uint64(content[4:fileHeaderSize]))
+	if expires == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, expires), nil
+}
+
+// 停止清理过期文件的goroutine
+func (self *FileDriver) Stop() {
+	self.stopOnce.Do(func() {
+		close(self.stop)
+	})
+}
+
+func (self *FileDriver) sweeper() {
+	ticker := time.NewTicker(self.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.gc()
+		case <-self.stop:
+			return
+		}
+	}
+}
+
+// 删除过期的session文件, 以及异常退出时残留的临时文件
+func (self *FileDriver) gc() {
+	now := time.Now()
+	shards, _ := os.ReadDir(self.dir)
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		dir := filepath.Join(self.dir, shard.Name())
+		files, _ := os.ReadDir(dir)
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			if strings.HasPrefix(file.Name(), fileTempPrefix) {
+				if info, err := file.Info(); err == nil && now.Sub(info.ModTime()) > time.Hour {
+					os.Remove(path)
+				}
+				continue
+			}
+			expires, err := readFileExpires(path)
+			if err == nil && !expires.IsZero() && now.After(expires) {
+				self.removeExpired(dir, path)
+			}
+		}
+	}
+}
+
+func readFileExpires(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	header := make([]byte, fileHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return time.Time{}, err
+	}
+	return parseFileHeader(header)
+}
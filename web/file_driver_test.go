@@ -0,0 +1,85 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDriver(t *testing.T) {
+	dir := t.TempDir()
+	driver, err := NewFileSessionDriver(dir, FileGCInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop()
+
+	err = driver.Set("../sid/1", []byte("dawn"), time.Hour)
+	if err != nil {
+		t.Fatal("Set error:", err)
+	}
+	value, err := driver.Get("../sid/1")
+	if err != nil || string(value) != "dawn" {
+		t.Error("Get error:", value, err)
+	}
+	if value, err := driver.Get("none"); value != nil || err != nil {
+		t.Error("Unknown key should return nil:", value, err)
+	}
+
+	driver.Set("short", []byte("value"), 10*time.Millisecond)
+	_, path := driver.path("short")
+	if filepath.Dir(filepath.Dir(path)) != dir {
+		t.Error("Session file should be in shard directory:", path)
+	}
+	time.Sleep(20 * time.Millisecond)
+	driver.gc()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expired session file should be removed")
+	}
+	if value, _ := driver.Get("../sid/1"); value == nil {
+		t.Error("Unexpired session should be kept")
+	}
+
+	os.WriteFile(path, []byte("bad"), 0600)
+	if _, err := driver.Get("short"); err != ErrBadSessionFile {
+		t.Error("Corrupt file error:", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "short"})
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	if _, err := sessionCtx.Loads(req); err != ErrInvalidSession {
+		t.Error("Corrupt file should be an invalid session:", err)
+	}
+}
+
+func TestFileDriverTouch(t *testing.T) {
+	driver, _ := NewFileSessionDriver(t.TempDir(), FileGCInterval(0))
+	defer driver.Stop()
+	driver.Set("sid", []byte("dawn"), 20*time.Millisecond)
+	if err := driver.Touch("sid", time.Hour); err != nil {
+		t.Fatal("Touch error:", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if value, _ := driver.Get("sid"); string(value) != "dawn" {
+		t.Error("Touched session should not expire:", value)
+	}
+
+	// 过期检查之后被Set更新的文件不会被删除
+	driver.Set("sid", []byte("old"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	shard, path := driver.path("sid")
+	driver.Set("sid", []byte("new"), time.Hour)
+	driver.removeExpired(shard, path)
+	if value, _ := driver.Get("sid"); string(value) != "new" {
+		t.Error("Refreshed session should be kept:", value)
+	}
+	if err := driver.Touch("none", time.Hour); err != nil {
+		t.Error("Touch unknown key error:", err)
+	}
+}
@@ -0,0 +1,103 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FLASH_KEY_PREFIX = "_flash:"
+)
+
+func flashPrefix(category string) string {
+	return FLASH_KEY_PREFIX + category + ":"
+}
+
+// 前缀之后只能是序号, 否则category为"a"时会取到"a:b"下的消息
+func flashKeys(data map[string]interface{}, category string) []string {
+	prefix := flashPrefix(category)
+	var keys []string
+	for key := range data {
+		if strings.HasPrefix(key, prefix) && isFlashSeq(key[len(prefix):]) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isFlashSeq(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 添加一条flash消息, 在下一次调用Flashes时取出并删除. 没有session时会创建新的session
+// (driver读取失败并且使用SESSION_FAIL_CLOSED时返回ErrDriverUnavailable).
+// 每条消息单独保存为session中的一个值, 取出时类型和保存时相同
+func (self *HttpContext) Flash(category string, message interface{}) error {
+	return self.flash(self.sessionStateOf(self.sessionCtx), category, message)
+}
+
+// 和Flash相同, 使用名字为name的SessionContext
+func (self *HttpContext) FlashNamed(name string, category string, message interface{}) error {
+	return self.flash(self.sessionStateOf(self.namedSessionCtx(name)), category, message)
+}
+
+func (self *HttpContext) flash(state *sessionState, category string, message interface{}) error {
+	session, err := self.loadSession(state)
+	if session == nil {
+		if !state.sessionCtx.replaceable(err) {
+			return err
+		}
+		session = self.newSession(state)
+	}
+	data, err := session.Values()
+	if err != nil {
+		return err
+	}
+	keys := flashKeys(data, category)
+	next := 0
+	if len(keys) > 0 {
+		last := keys[len(keys)-1]
+		fmt.Sscanf(last[len(flashPrefix(category)):], "%d", &next)
+		next++
+	}
+	return session.Set(fmt.Sprintf("%s%08d", flashPrefix(category), next), message)
+}
+
+// 取出并删除category下所有的flash消息, 按添加的顺序返回
+func (self *HttpContext) Flashes(category string) []interface{} {
+	return self.flashes(self.sessionStateOf(self.sessionCtx), category)
+}
+
+// 和Flashes相同, 使用名字为name的SessionContext
+func (self *HttpContext) FlashesNamed(name string, category string) []interface{} {
+	return self.flashes(self.sessionStateOf(self.namedSessionCtx(name)), category)
+}
+
+func (self *HttpContext) flashes(state *sessionState, category string) []interface{} {
+	session, _ := self.loadSession(state)
+	if session == nil {
+		return nil
+	}
+	data, err := session.Values()
+	if err != nil {
+		return nil
+	}
+	var messages []interface{}
+	for _, key := range flashKeys(data, category) {
+		messages = append(messages, data[key])
+		session.Delete(key)
+	}
+	return messages
+}
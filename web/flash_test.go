@@ -0,0 +1,41 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlash(t *testing.T) {
+	server := newSessionServer(NewMemorySessionDriver(MemoryGCInterval(0)))
+	server.AddHandler("/post", func(ctx *HttpContext) {
+		ctx.Flash("notice", "saved")
+		ctx.Flash("notice", 2)
+		ctx.Flash("error", "failed")
+		// 包含":"的category不会和"notice"混在一起
+		ctx.Flash("notice:extra", "x")
+	})
+	var notices, errors, extras []interface{}
+	server.AddHandler("/get", func(ctx *HttpContext) {
+		notices = ctx.Flashes("notice")
+		errors = ctx.Flashes("error")
+		extras = ctx.Flashes("notice:extra")
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/post", nil))
+	cookie := sessionCookie(resp)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/get", nil)
+		req.AddCookie(cookie)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+		if i == 0 && (len(notices) != 2 || notices[0] != "saved" || notices[1] != 2 || len(errors) != 1 ||
+			len(extras) != 1) {
+			t.Error("Flashes error:", notices, errors, extras)
+		}
+	}
+	if len(notices) != 0 || len(errors) != 0 {
+		t.Error("Flashes should be consumed:", notices, errors)
+	}
+}
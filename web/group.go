@@ -0,0 +1,59 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 共用URL前缀和路由选项的一组路由, 例如:
+//
+//	admin := server.Group("/admin", RouteSession("admin"))
+//	admin.AddHandler("/users", ListUsers)
+type RouteGroup struct {
+	server *HttpServer
+	prefix string
+	opts   []RouteOption
+}
+
+func (self *HttpServer) Group(prefix string, opts ...RouteOption) *RouteGroup {
+	return &RouteGroup{server: self, prefix: strings.TrimRight(prefix, "/"), opts: opts}
+}
+
+// 子分组的前缀和选项都追加在当前分组之后
+func (self *RouteGroup) Group(prefix string, opts ...RouteOption) *RouteGroup {
+	return &RouteGroup{
+		server: self.server,
+		prefix: self.prefix + strings.TrimRight(prefix, "/"),
+		opts:   self.merge(opts),
+	}
+}
+
+// 路由自己的选项在分组的选项之后执行, 可以覆盖分组的设置
+func (self *RouteGroup) merge(opts []RouteOption) []RouteOption {
+	result := make([]RouteOption, 0, len(self.opts)+len(opts))
+	result = append(result, self.opts...)
+	return append(result, opts...)
+}
+
+// 前缀加在匹配标记之后, 正则路由的前缀会被转义
+func (self *RouteGroup) pattern(urlPattern string) string {
+	switch urlPattern[0] {
+	case '=', '~':
+		return urlPattern[:1] + self.prefix + urlPattern[1:]
+	case '^':
+		return "^" + regexp.QuoteMeta(self.prefix) + urlPattern[1:]
+	}
+	return self.prefix + urlPattern
+}
+
+func (self *RouteGroup) AddHandler(urlPattern string, handler Handler, opts ...RouteOption) error {
+	return self.server.AddHandler(self.pattern(urlPattern), handler, self.merge(opts)...)
+}
+
+// 分组的超时设置对WebSocket路由无效, 接管连接需要不带超时的ResponseWriter
+func (self *RouteGroup) WebSocket(urlPattern string, handler WSHandler, opts ...RouteOption) error {
+	opts = append(self.merge(opts), RouteTimeout(0))
+	return self.server.AddHandler(self.pattern(urlPattern), wsHandler(handler), opts...)
+}
@@ -0,0 +1,139 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteGroup(t *testing.T) {
+	server := NewServer(NewHttpConfig(":0"), nil, &discardHandler{})
+	api := server.Group("/api/", RouteMaxBodySize(10))
+	v1 := api.Group("/v1", RouteTimeout(time.Second))
+	var limit int64
+	v1.AddHandler("/users", func(ctx *HttpContext) {
+		limit = ctx.route.maxBodySize
+		ctx.Response.Write([]byte("users"))
+	}, RouteMaxBodySize(20))
+	v1.AddHandler("~/static", func(ctx *HttpContext) {
+		ctx.Response.Write([]byte("static"))
+	})
+	v1.AddHandler("^/item/{id: [0-9]+}$", func(ctx *HttpContext) {
+		ctx.Response.Write([]byte("item " + ctx.GetVar("id")))
+	})
+
+	for uri, expected := range map[string]string{
+		"/api/v1/users":        "users",
+		"/api/v1/static/a.css": "static",
+		"/api/v1/item/42":      "item 42",
+	} {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest("GET", uri, nil))
+		if resp.Body.String() != expected {
+			t.Error("Group route error:", uri, resp.Code, resp.Body.String())
+		}
+	}
+	if limit != 20 {
+		t.Error("Route option should override group option:", limit)
+	}
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/users", nil))
+	if resp.Code != 404 {
+		t.Error("Route without prefix should not match:", resp.Code)
+	}
+
+	// 分组的超时不能影响WebSocket的升级
+	v1.WebSocket("/ws", func(conn *WSConn, ctx *HttpContext) {})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	if _, resp := dialWebSocket(t, ts, "/api/v1/ws"); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Error("Group WebSocket upgrade error:", resp.StatusCode)
+	}
+}
+
+func TestNamedSessionContext(t *testing.T) {
+	publicDriver := NewMemorySessionDriver(MemoryGCInterval(0))
+	adminDriver := NewMemorySessionDriver(MemoryGCInterval(0))
+	server := newSessionServer(publicDriver)
+	server.AddSessionContext("admin",
+		NewSessionContext(adminDriver, "admin_sid", "", time.Hour, "/admin", true, true, 10*time.Minute))
+
+	admin := server.Group("/admin", RouteSession("admin"))
+	admin.AddHandler("/login", func(ctx *HttpContext) {
+		session := ctx.NewSession()
+		session.Set("admin", "root")
+		if named, _ := ctx.SessionNamed("admin"); named != session {
+			t.Error("Bound session should be the same as named session")
+		}
+	})
+	server.AddHandler("/", func(ctx *HttpContext) {
+		ctx.NewSession().Set("user", "guest")
+		session, err := ctx.SessionNamed("admin")
+		if err != nil {
+			t.Error("Named session error:", err)
+			return
+		}
+		if name, _ := session.GetString("admin"); name != "root" {
+			t.Error("Named session value error:", name)
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/admin/login", nil))
+	cookies := resp.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "admin_sid" || cookies[0].Path != "/admin" || !cookies[0].Secure {
+		t.Fatal("Admin cookie error:", cookies)
+	}
+	if publicDriver.Len() != 0 || adminDriver.Len() != 1 {
+		t.Error("Admin session should be saved in admin driver:", publicDriver.Len(), adminDriver.Len())
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if cookie := sessionCookie(resp); cookie == nil || publicDriver.Len() != 1 {
+		t.Error("Public session should use default context")
+	}
+
+	if err := server.AddHandler("/bad", func(ctx *HttpContext) {}, RouteSession("none")); err != ErrSessionNotSetup {
+		t.Error("Unknown route session should be rejected:", err)
+	}
+	server.AddHandler("/switch", func(ctx *HttpContext) {
+		old, _ := ctx.SessionNamed("admin")
+		oldID, _ := old.ID()
+		ctx.FlashNamed("admin", "info", "hi")
+		if messages := ctx.FlashesNamed("admin", "info"); len(messages) != 1 || messages[0] != "hi" {
+			t.Error("Named flash error:", messages)
+		}
+		session, err := ctx.RegenerateSessionNamed("admin")
+		if id, _ := session.ID(); err != nil || id == oldID {
+			t.Error("Named regenerate error:", err)
+		}
+		session.Set("k", 1)
+		if err := ctx.SaveSessionNamed("admin"); err != nil {
+			t.Error("Named save error:", err)
+		}
+		if err := ctx.DestroySessionNamed("admin"); err != nil || adminDriver.Len() != 0 {
+			t.Error("Named destroy error:", err, adminDriver.Len())
+		}
+	})
+	req = httptest.NewRequest("GET", "/switch", nil)
+	req.AddCookie(cookies[0])
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	if publicDriver.Len() != 1 {
+		t.Error("Named session operations should not touch default context:", publicDriver.Len())
+	}
+
+	defer func() {
+		if recover() != ErrSessionNotSetup {
+			t.Error("Unknown session name should panic")
+		}
+	}()
+	ctx := NewHttpContext(httptest.NewRecorder(), req, nil, nil)
+	ctx.route = &route{server: server}
+	ctx.SessionNamed("none")
+}
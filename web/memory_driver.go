@@ -0,0 +1,292 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	DEFAULT_MEMORY_SHARDS      = 16
+	DEFAULT_MEMORY_GC_INTERVAL = time.Minute
+)
+
+type MemoryDriverOption func(*MemoryDriver)
+
+// 分片数量, 越多锁竞争越少
+func MemoryShards(n int) MemoryDriverOption {
+	return func(d *MemoryDriver) {
+		if n > 0 {
+			d.shardCount = n
+		}
+	}
+}
+
+// 最多保存的session数量, 所有分片共用这个上限, 超出时淘汰所有分片中最久没有访问的session, n<=0表示不限制
+func MemoryMaxEntries(n int) MemoryDriverOption {
+	return func(d *MemoryDriver) {
+		d.maxEntries = n
+	}
+}
+
+// 清理过期session的间隔, interval<=0表示不启动清理的goroutine, 过期的session只在读取时删除
+func MemoryGCInterval(interval time.Duration) MemoryDriverOption {
+	return func(d *MemoryDriver) {
+		d.gcInterval = interval
+	}
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	version int64
+	expires time.Time
+	used    uint64 // 最后访问的序号, 用于在分片之间比较访问先后
+	elem    *list.Element
+}
+
+func (self *memoryEntry) expired(now time.Time) bool {
+	return !self.expires.IsZero() && now.After(self.expires)
+}
+
+type memoryShard struct {
+	sync.Mutex
+	driver  *MemoryDriver
+	entries map[string]*memoryEntry
+	lru     *list.List
+}
+
+// 保存在进程内存中的SessionDriver, 适合本地开发, 测试和单机部署
+type MemoryDriver struct {
+	shards     []*memoryShard
+	shardCount int
+	maxEntries int
+	gcInterval time.Duration
+
+	count int64  // 所有分片的session数量
+	seq   uint64 // 访问序号
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewMemorySessionDriver(opts ...MemoryDriverOption) *MemoryDriver {
+	driver := &MemoryDriver{
+		shardCount: DEFAULT_MEMORY_SHARDS,
+		gcInterval: DEFAULT_MEMORY_GC_INTERVAL,
+		stop:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(driver)
+	}
+	driver.shards = make([]*memoryShard, driver.shardCount)
+	for idx := range driver.shards {
+		driver.shards[idx] = &memoryShard{driver: driver, entries: make(map[string]*memoryEntry), lru: list.New()}
+	}
+	if driver.gcInterval > 0 {
+		go driver.janitor()
+	}
+	return driver
+}
+
+func (self *MemoryDriver) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return self.shards[h.Sum32()%uint32(len(self.shards))]
+}
+
+// key不存在或已过期时返回nil
+func (self *MemoryDriver) Get(key string) ([]byte, error) {
+	shard := self.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	entry := shard.get(key)
+	if entry == nil {
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+// expire<=0表示不过期
+func (self *MemoryDriver) Set(key string, value []byte, expire time.Duration) error {
+	shard := self.shard(key)
+	shard.Lock()
+	shard.set(key, value, expire)
+	shard.Unlock()
+	self.evict()
+	return nil
+}
+
+func (self *MemoryDriver) GetVersion(key string) ([]byte, int64, error) {
+	shard := self.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	entry := shard.get(key)
+	if entry == nil {
+		return nil, 0, nil
+	}
+	return entry.value, entry.version, nil
+}
+
+func (self *MemoryDriver) SetIfVersion(key string, value []byte, version int64, expire time.Duration) (int64, error) {
+	shard := self.shard(key)
+	shard.Lock()
+	var current int64
+	if entry := shard.get(key); entry != nil {
+		current = entry.version
+	}
+	if current != version {
+		shard.Unlock()
+		return 0, ErrSessionConflict
+	}
+	version = shard.set(key, value, expire)
+	shard.Unlock()
+	self.evict()
+	return version, nil
+}
+
+func (self *MemoryDriver) Delete(key string) error {
+	shard := self.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if entry, ok := shard.entries[key]; ok {
+		shard.remove(entry)
+	}
+	return nil
+}
+
+func (self *MemoryDriver) Touch(key string, expire time.Duration) error {
+	shard := self.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+	if entry, ok := shard.entries[key]; ok {
+		entry.expires = time.Time{}
+		if expire > 0 {
+			entry.expires = time.Now().Add(expire)
+		}
+	}
+	return nil
+}
+
+// 当前保存的session数量, 包括已过期但还没有被清理的
+func (self *MemoryDriver) Len() int {
+	var n int
+	for _, shard := range self.shards {
+		shard.Lock()
+		n += len(shard.entries)
+		shard.Unlock()
+	}
+	return n
+}
+
+// 停止清理过期session的goroutine
+func (self *MemoryDriver) Stop() {
+	self.stopOnce.Do(func() {
+		close(self.stop)
+	})
+}
+
+func (self *MemoryDriver) janitor() {
+	ticker := time.NewTicker(self.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.gc()
+		case <-self.stop:
+			return
+		}
+	}
+}
+
+// 超出maxEntries时淘汰所有分片中最久没有访问的session.
+// 每个分片的lru尾部就是该分片最久没有访问的, 比较各分片尾部的访问序号即可找到全局最旧的
+func (self *MemoryDriver) evict() {
+	if self.maxEntries <= 0 {
+		return
+	}
+	max := int64(self.maxEntries)
+	for atomic.LoadInt64(&self.count) > max {
+		var oldest *memoryShard
+		var used uint64
+		for _, shard := range self.shards {
+			shard.Lock()
+			if elem := shard.lru.Back(); elem != nil {
+				if entry := elem.Value.(*memoryEntry); oldest == nil || entry.used < used {
+					oldest, used = shard, entry.used
+				}
+			}
+			shard.Unlock()
+		}
+		if oldest == nil {
+			return
+		}
+		oldest.Lock()
+		// 比较之后可能已经被访问或被其它goroutine淘汰, 这时重新查找
+		if elem := oldest.lru.Back(); elem != nil && atomic.LoadInt64(&self.count) > max {
+			if entry := elem.Value.(*memoryEntry); entry.used == used {
+				oldest.remove(entry)
+			}
+		}
+		oldest.Unlock()
+	}
+}
+
+func (self *MemoryDriver) gc() {
+	now := time.Now()
+	for _, shard := range self.shards {
+		shard.Lock()
+		for _, entry := range shard.entries {
+			if entry.expired(now) {
+				shard.remove(entry)
+			}
+		}
+		shard.Unlock()
+	}
+}
+
+func (self *memoryShard) get(key string) *memoryEntry {
+	entry, ok := self.entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.expired(time.Now()) {
+		self.remove(entry)
+		return nil
+	}
+	entry.used = atomic.AddUint64(&self.driver.seq, 1)
+	self.lru.MoveToFront(entry.elem)
+	return entry
+}
+
+// 写入并返回新的版本号, 过期的session重新从1开始
+func (self *memoryShard) set(key string, value []byte, expire time.Duration) int64 {
+	var expires time.Time
+	if expire > 0 {
+		expires = time.Now().Add(expire)
+	}
+	// 复制一份, 调用方之后修改value不会影响已保存的数据
+	value = append([]byte(nil), value...)
+	if entry := self.get(key); entry != nil {
+		entry.value = value
+		entry.expires = expires
+		entry.version++
+		return entry.version
+	}
+	entry := &memoryEntry{key: key, value: value, version: 1, expires: expires}
+	entry.used = atomic.AddUint64(&self.driver.seq, 1)
+	entry.elem = self.lru.PushFront(entry)
+	self.entries[key] = entry
+	atomic.AddInt64(&self.driver.count, 1)
+	return entry.version
+}
+
+func (self *memoryShard) remove(entry *memoryEntry) {
+	self.lru.Remove(entry.elem)
+	delete(self.entries, entry.key)
+	atomic.AddInt64(&self.driver.count, -1)
+}
@@ -0,0 +1,78 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryDriver(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(10 * time.Millisecond))
+	defer driver.Stop()
+
+	data := []byte("dawn")
+	driver.Set("a", data, time.Hour)
+	driver.Set("b", data, 20*time.Millisecond)
+	data[0] = 'x'
+	value, err := driver.Get("a")
+	if err != nil || string(value) != "dawn" {
+		t.Error("Get error:", value, err)
+	}
+	if value, _ := driver.Get("none"); value != nil {
+		t.Error("Unknown key should return nil:", value)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if driver.Len() != 1 {
+		t.Error("Expired session should be collected:", driver.Len())
+	}
+	if value, _ := driver.Get("b"); value != nil {
+		t.Error("Expired session should return nil:", value)
+	}
+}
+
+func TestMemoryDriverLRU(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryShards(1), MemoryMaxEntries(3), MemoryGCInterval(0))
+	for i := 0; i < 3; i++ {
+		driver.Set(fmt.Sprint(i), []byte(fmt.Sprint(i)), time.Hour)
+	}
+	driver.Get("0")
+	driver.Set("3", []byte("3"), time.Hour)
+	if driver.Len() != 3 {
+		t.Error("Max entries error:", driver.Len())
+	}
+	if value, _ := driver.Get("1"); value != nil {
+		t.Error("Least recently used session should be evicted")
+	}
+	if value, _ := driver.Get("0"); string(value) != "0" {
+		t.Error("Recently used session should be kept:", value)
+	}
+}
+
+func TestMemoryDriverMaxEntriesShards(t *testing.T) {
+	// 默认分片数下上限也是全局的
+	driver := NewMemorySessionDriver(MemoryMaxEntries(20), MemoryGCInterval(0))
+	for i := 0; i < 20; i++ {
+		driver.Set(fmt.Sprint(i), []byte(fmt.Sprint(i)), time.Hour)
+	}
+	if driver.Len() != 20 {
+		t.Error("Sessions should not be evicted under the limit:", driver.Len())
+	}
+	for i := 0; i < 10; i++ {
+		driver.Get(fmt.Sprint(i))
+	}
+	for i := 20; i < 30; i++ {
+		driver.Set(fmt.Sprint(i), []byte(fmt.Sprint(i)), time.Hour)
+	}
+	if driver.Len() != 20 {
+		t.Error("Max entries error:", driver.Len())
+	}
+	for i := 0; i < 30; i++ {
+		value, _ := driver.Get(fmt.Sprint(i))
+		if evicted := i >= 10 && i < 20; evicted != (value == nil) {
+			t.Error("Global LRU eviction error:", i, value)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotAcceptable = errors.New("NotAcceptable")
+)
+
+type Encoder interface {
+	Encode(w io.Writer, v interface{}) error
+}
+
+type EncoderFunc func(w io.Writer, v interface{}) error
+
+func (self EncoderFunc) Encode(w io.Writer, v interface{}) error {
+	return self(w, v)
+}
+
+type registeredEncoder struct {
+	mediaType   string
+	contentType string
+	encoder     Encoder
+}
+
+var (
+	encoderLock sync.RWMutex
+	encoders    []*registeredEncoder
+)
+
+func init() {
+	RegisterEncoder("application/json", "application/json; charset=utf-8", EncoderFunc(encodeJSON))
+	RegisterEncoder("application/xml", "application/xml; charset=utf-8", EncoderFunc(encodeXML))
+	RegisterEncoder("text/xml", "text/xml; charset=utf-8", EncoderFunc(encodeXML))
+	RegisterEncoder("application/msgpack", "application/msgpack", EncoderFunc(encodeMsgpack))
+	RegisterEncoder("application/x-msgpack", "application/x-msgpack", EncoderFunc(encodeMsgpack))
+	RegisterEncoder("text/plain", "text/plain; charset=utf-8", EncoderFunc(encodeText))
+}
+
+// 注册或替换mediaType对应的Encoder, 当Accept中多个类型的权重相同时按注册顺序优先,
+// 请求没有Accept头时使用第一个注册的Encoder(application/json)
+func RegisterEncoder(mediaType string, contentType string, encoder Encoder) {
+	mediaType = strings.ToLower(mediaType)
+	encoderLock.Lock()
+	defer encoderLock.Unlock()
+	for idx, enc := range encoders {
+		if enc.mediaType == mediaType {
+			// 替换为新的对象, 正在使用旧对象的请求不受影响
+			encoders[idx] = &registeredEncoder{mediaType, contentType, encoder}
+			return
+		}
+	}
+	encoders = append(encoders, &registeredEncoder{mediaType, contentType, encoder})
+}
+
+//------------------ Accept ------------------
+
+type acceptRange struct {
+	mediaType string
+	subType   string
+	quality   float64
+}
+
+func (self *acceptRange) specificity() int {
+	if self.mediaType == "*" {
+		return 0
+	}
+	if self.subType == "*" {
+		return 1
+	}
+	return 2
+}
+
+func (self *acceptRange) match(mediaType string) bool {
+	if self.mediaType == "*" {
+		return true
+	}
+	idx := strings.IndexByte(mediaType, '/')
+	if mediaType[:idx] != self.mediaType {
+		return false
+	}
+	return self.subType == "*" || mediaType[idx+1:] == self.subType
+}
+
+func parseAccept(accept string) []*acceptRange {
+	ranges := make([]*acceptRange, 0, 4)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		idx := strings.IndexByte(mediaType, '/')
+		if idx <= 0 || idx == len(mediaType)-1 {
+			continue
+		}
+		r := &acceptRange{mediaType[:idx], mediaType[idx+1:], 1}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				r.quality = q
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	// 越具体的类型越优先匹配
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+	return ranges
+}
+
+func negotiateEncoder(accept string) *registeredEncoder {
+	encoderLock.RLock()
+	defer encoderLock.RUnlock()
+	if len(encoders) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(accept) == "" {
+		return encoders[0]
+	}
+	ranges := parseAccept(accept)
+	var best *registeredEncoder
+	var bestQuality float64
+	bestSpecificity := -1
+	for _, enc := range encoders {
+		for _, r := range ranges {
+			if !r.match(enc.mediaType) {
+				continue
+			}
+			// 权重相同时, 明确列出的类型优先于通配符
+			spec := r.specificity()
+			if r.quality > bestQuality || (r.quality == bestQuality && r.quality > 0 && spec > bestSpecificity) {
+				best = enc
+				bestQuality = r.quality
+				bestSpecificity = spec
+			}
+			break
+		}
+	}
+	return best
+}
+
+// 根据请求的Accept头选择Encoder输出v, 没有可用的Encoder时返回406和ErrNotAcceptable
+func (self *HttpContext) Negotiate(status int, v interface{}) error {
+	resp := self.Response
+	resp.Header().Add("Vary", "Accept")
+	enc := negotiateEncoder(self.Request.Header.Get("Accept"))
+	if enc == nil {
+		code := http.StatusNotAcceptable
+		http.Error(resp, http.StatusText(code), code)
+		return ErrNotAcceptable
+	}
+	var buf bytes.Buffer
+	if err := enc.encoder.Encode(&buf, v); err != nil {
+		return err
+	}
+	resp.Header().Set("Content-Type", enc.contentType)
+	resp.WriteHeader(status)
+	_, err := resp.Write(buf.Bytes())
+	return err
+}
+
+//------------------ builtin encoders ------------------
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	return msgpack.NewEncoder(w).Encode(v)
+}
+
+func encodeText(w io.Writer, v interface{}) error {
+	var err error
+	switch value := v.(type) {
+	case []byte:
+		_, err = w.Write(value)
+	case string:
+		_, err = io.WriteString(w, value)
+	case fmt.Stringer:
+		_, err = io.WriteString(w, value.String())
+	case error:
+		_, err = io.WriteString(w, value.Error())
+	default:
+		_, err = fmt.Fprintf(w, "%v", value)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"github.com/vmihailenco/msgpack"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type negotiateData struct {
+	Name string `json:"name" xml:"name" msgpack:"name"`
+}
+
+func (self negotiateData) String() string {
+	return "name=" + self.Name
+}
+
+func negotiate(accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp := httptest.NewRecorder()
+	ctx := NewHttpContext(resp, req, nil, nil)
+	ctx.Negotiate(http.StatusCreated, negotiateData{"dawn"})
+	return resp
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "application/json", `{"name":"dawn"}`},
+		{"text/html, application/xml;q=0.9, */*;q=0.1", "application/xml", "<negotiateData><name>dawn</name></negotiateData>"},
+		{"application/xml, */*", "application/xml", "<negotiateData>"},
+		{"text/*;q=0.5, application/json;q=0.4", "text/xml", "<negotiateData>"},
+		{"text/plain, text/xml;q=0", "text/plain", "name=dawn"},
+	}
+	for _, c := range cases {
+		resp := negotiate(c.accept)
+		if resp.Code != http.StatusCreated {
+			t.Error("Negotiate status error:", c.accept, resp.Code)
+		}
+		if !strings.HasPrefix(resp.Header().Get("Content-Type"), c.contentType) {
+			t.Error("Negotiate content type error:", c.accept, resp.Header().Get("Content-Type"))
+		}
+		if !strings.HasPrefix(resp.Body.String(), c.body) {
+			t.Error("Negotiate body error:", c.accept, resp.Body.String())
+		}
+	}
+
+	resp := negotiate("application/msgpack")
+	var data negotiateData
+	if err := msgpack.Unmarshal(resp.Body.Bytes(), &data); err != nil || data.Name != "dawn" {
+		t.Error("Msgpack encode error:", err, data)
+	}
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	resp := negotiate("image/png, application/json;q=0")
+	if resp.Code != http.StatusNotAcceptable {
+		t.Error("Negotiate should return 406:", resp.Code)
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	encoderLock.RLock()
+	saved := append([]*registeredEncoder(nil), encoders...)
+	encoderLock.RUnlock()
+	t.Cleanup(func() {
+		encoderLock.Lock()
+		encoders = saved
+		encoderLock.Unlock()
+	})
+
+	RegisterEncoder("text/csv", "text/csv", EncoderFunc(func(w io.Writer, v interface{}) error {
+		_, err := io.WriteString(w, "name\n"+v.(negotiateData).Name)
+		return err
+	}))
+	resp := negotiate("text/csv")
+	if resp.Header().Get("Content-Type") != "text/csv" || resp.Body.String() != "name\ndawn" {
+		t.Error("Custom encoder error:", resp.Header().Get("Content-Type"), resp.Body.String())
+	}
+
+	// 重新注册不会修改之前取得的Encoder
+	old := negotiateEncoder("text/csv")
+	RegisterEncoder("text/csv", "text/csv; charset=utf-8", EncoderFunc(encodeText))
+	if old.contentType != "text/csv" || negotiateEncoder("text/csv").contentType != "text/csv; charset=utf-8" {
+		t.Error("Re-register encoder error:", old.contentType)
+	}
+}
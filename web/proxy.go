@@ -0,0 +1,214 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidProxy = errors.New("InvalidTrustedProxy")
+)
+
+// 经过代理后还原出的客户端信息
+type clientInfo struct {
+	ip     string
+	scheme string
+	host   string
+}
+
+type forwardedHop struct {
+	addr  string
+	proto string
+	host  string
+}
+
+// 设置可信的代理, 参数为CIDR或单个IP, 只有请求来自可信代理时才会使用
+// Forwarded, X-Forwarded-For, X-Real-IP, X-Forwarded-Proto和X-Forwarded-Host
+func (self *HttpServer) SetTrustedProxies(cidrs ...string) error {
+	proxies := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return ErrInvalidProxy
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return ErrInvalidProxy
+		}
+		proxies = append(proxies, network)
+	}
+	self.trustedProxies = proxies
+	return nil
+}
+
+func (self *HttpServer) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range self.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func directClient(req *http.Request) *clientInfo {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return &clientInfo{remoteHost(req.RemoteAddr), scheme, req.Host}
+}
+
+// 从右往左跳过可信的代理, 第一个不可信的地址就是客户端
+func (self *HttpServer) resolveClient(req *http.Request) *clientInfo {
+	client := directClient(req)
+	if len(self.trustedProxies) == 0 || !self.isTrusted(client.ip) {
+		return client
+	}
+
+	var hops []*forwardedHop
+	if values := req.Header.Values("Forwarded"); len(values) > 0 {
+		hops = parseForwarded(strings.Join(values, ","))
+	} else if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, addr := range strings.Split(strings.Join(values, ","), ",") {
+			hops = append(hops, &forwardedHop{addr: strings.TrimSpace(addr)})
+		}
+		if len(hops) > 0 {
+			last := hops[len(hops)-1]
+			last.proto = lastValue(req.Header.Get("X-Forwarded-Proto"))
+			last.host = lastValue(req.Header.Get("X-Forwarded-Host"))
+		}
+	} else if addr := strings.TrimSpace(req.Header.Get("X-Real-IP")); addr != "" {
+		hops = []*forwardedHop{{addr: addr}}
+	}
+
+	var proto, host string
+	for idx := len(hops) - 1; idx >= 0; idx-- {
+		hop := hops[idx]
+		// 代理不一定会传递协议和host, 使用最靠近客户端的那个
+		if hop.proto != "" {
+			proto = hop.proto
+		}
+		if hop.host != "" {
+			host = hop.host
+		}
+		addr := forwardedAddr(hop.addr)
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		client.ip = addr
+		if !self.isTrusted(addr) {
+			break
+		}
+	}
+	proto = strings.ToLower(proto)
+	if proto == "http" || proto == "https" || proto == "ws" || proto == "wss" {
+		client.scheme = proto
+	}
+	if host != "" {
+		client.host = host
+	}
+	return client
+}
+
+func lastValue(value string) string {
+	if idx := strings.LastIndexByte(value, ','); idx >= 0 {
+		value = value[idx+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+// RFC 7239: Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+func parseForwarded(value string) []*forwardedHop {
+	var hops []*forwardedHop
+	for _, element := range splitQuoted(value, ',') {
+		hop := &forwardedHop{}
+		for _, pair := range splitQuoted(element, ';') {
+			idx := strings.IndexByte(pair, '=')
+			if idx < 0 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(pair[:idx]))
+			value := strings.Trim(strings.TrimSpace(pair[idx+1:]), `"`)
+			switch key {
+			case "for":
+				hop.addr = value
+			case "proto":
+				hop.proto = value
+			case "host":
+				hop.host = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+func splitQuoted(value string, sep byte) []string {
+	var result []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				result = append(result, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, value[start:])
+}
+
+// 去掉端口和IPv6的方括号
+func forwardedAddr(addr string) string {
+	if strings.HasPrefix(addr, "[") {
+		if idx := strings.IndexByte(addr, ']'); idx > 0 {
+			return addr[1:idx]
+		}
+		return addr
+	}
+	if strings.Count(addr, ":") == 1 {
+		return addr[:strings.IndexByte(addr, ':')]
+	}
+	return addr
+}
+
+func (self *HttpContext) clientInfo() *clientInfo {
+	if self.client == nil {
+		self.client = directClient(self.Request)
+	}
+	return self.client
+}
+
+// 客户端的真实IP, 请求来自可信代理时从转发头中解析
+func (self *HttpContext) ClientIP() string {
+	return self.clientInfo().ip
+}
+
+// 客户端使用的协议, http或https
+func (self *HttpContext) Scheme() string {
+	return self.clientInfo().scheme
+}
+
+// 客户端请求的host, 请求来自可信代理时优先使用转发头中的host
+func (self *HttpContext) Host() string {
+	return self.clientInfo().host
+}
@@ -0,0 +1,50 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	server := newTestServer()
+	if server.SetTrustedProxies("10.0.0.0/8", "bad") != ErrInvalidProxy {
+		t.Error("Invalid proxy should be rejected")
+	}
+	server.SetTrustedProxies("10.0.0.0/8", "192.168.1.1", "fd00::/8")
+	var ip, scheme, host string
+	server.AddHandler("/", func(ctx *HttpContext) {
+		ip, scheme, host = ctx.ClientIP(), ctx.Scheme(), ctx.Host()
+	})
+	serve := func(remote string, headers map[string]string) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = remote
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("1.1.1.1:1234", map[string]string{"X-Forwarded-For": "2.2.2.2", "X-Forwarded-Proto": "https"})
+	if ip != "1.1.1.1" || scheme != "http" || host != "example.com" {
+		t.Error("Untrusted remote should be used directly:", ip, scheme, host)
+	}
+
+	serve("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 2.2.2.2, 192.168.1.1",
+		"X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"})
+	if ip != "2.2.2.2" || scheme != "https" || host != "www.example.com" {
+		t.Error("X-Forwarded-For error:", ip, scheme, host)
+	}
+
+	serve("10.0.0.1:1234", map[string]string{"X-Real-IP": "3.3.3.3"})
+	if ip != "3.3.3.3" {
+		t.Error("X-Real-IP error:", ip)
+	}
+
+	serve("[fd00::1]:1234", map[string]string{
+		"Forwarded": `for="[2001:db8::17]:4711";proto=https;host="a.example.com", for=10.0.0.2;proto=http`})
+	if ip != "2001:db8::17" || scheme != "https" || host != "a.example.com" {
+		t.Error("Forwarded error:", ip, scheme, host)
+	}
+}
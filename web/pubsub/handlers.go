@@ -0,0 +1,150 @@
+//Copyright (C) Mr.Pungle
+
+package pubsub
+
+import (
+	"encoding/json"
+	"github.com/pungle/dawn/web"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 从请求中得到topic名字, 返回空字符串时响应400
+type TopicFunc func(ctx *web.HttpContext) string
+
+func QueryTopic(name string) TopicFunc {
+	return func(ctx *web.HttpContext) string {
+		return ctx.Request.URL.Query().Get(name)
+	}
+}
+
+func VarTopic(name string) TopicFunc {
+	return func(ctx *web.HttpContext) string {
+		return ctx.GetVar(name)
+	}
+}
+
+type pollMessage struct {
+	ID   uint64      `json:"id"`
+	Data interface{} `json:"data"`
+}
+
+func writeMessages(ctx *web.HttpContext, messages []*Message) {
+	result := make([]*pollMessage, len(messages))
+	for idx, msg := range messages {
+		result[idx] = &pollMessage{msg.ID, msg.Data}
+	}
+	data, _ := json.Marshal(result)
+	ctx.Response.Header().Set("Content-Type", "application/json")
+	ctx.Response.Header().Set("Cache-Control", "no-cache")
+	ctx.Response.Write(data)
+}
+
+// 长轮询: 请求参数since为客户端收到的最后一条消息ID, 有错过的历史消息时立即返回,
+// 否则等待新消息直到wait超时或客户端断开, 没有since时只等待之后发布的消息, 响应为[{"id":1,"data":...}]
+func LongPollHandler(hub *Hub, topicFunc TopicFunc, wait time.Duration) web.Handler {
+	return func(ctx *web.HttpContext) {
+		name := topicFunc(ctx)
+		if name == "" {
+			http.Error(ctx.Response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		var sub *Subscription
+		var missed []*Message
+		var err error
+		if value := ctx.Request.URL.Query().Get("since"); value == "" {
+			sub, err = hub.Subscribe(name)
+		} else {
+			since, _ := strconv.ParseUint(value, 10, 64)
+			sub, missed, err = hub.SubscribeFrom(name, since)
+		}
+		if err != nil {
+			http.Error(ctx.Response, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer sub.Close()
+		if len(missed) > 0 {
+			writeMessages(ctx, missed)
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case msg := <-sub.C:
+			// 顺便带上已经到达的消息
+			messages := []*Message{msg}
+			for len(sub.C) > 0 {
+				messages = append(messages, <-sub.C)
+			}
+			writeMessages(ctx, messages)
+		case <-timer.C:
+			writeMessages(ctx, nil)
+		case <-sub.Done():
+			writeMessages(ctx, nil)
+		case <-ctx.Context().Done():
+		}
+	}
+}
+
+func eventData(data interface{}) string {
+	switch value := data.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+	encoded, _ := json.Marshal(data)
+	return string(encoded)
+}
+
+// 以Server-Sent Events推送topic中的消息, 事件ID为消息ID, 客户端重连时根据Last-Event-ID补发历史消息.
+// 注册路由时需要使用web.RouteTimeout(0)
+func SSEHandler(hub *Hub, topicFunc TopicFunc, heartbeat time.Duration) web.Handler {
+	return func(ctx *web.HttpContext) {
+		name := topicFunc(ctx)
+		if name == "" {
+			http.Error(ctx.Response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		lastID, _ := strconv.ParseUint(ctx.Request.Header.Get("Last-Event-ID"), 10, 64)
+		sub, missed, err := hub.SubscribeFrom(name, lastID)
+		if err != nil {
+			http.Error(ctx.Response, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer sub.Close()
+
+		stream, err := ctx.SSE()
+		if err != nil {
+			return
+		}
+		if heartbeat > 0 {
+			stream.Heartbeat(heartbeat)
+		}
+		send := func(msg *Message) error {
+			return stream.Send(&web.Event{
+				ID:   strconv.FormatUint(msg.ID, 10),
+				Data: eventData(msg.Data),
+			})
+		}
+		for _, msg := range missed {
+			if send(msg) != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case msg := <-sub.C:
+				if send(msg) != nil {
+					return
+				}
+			case <-sub.Done():
+				return
+			case <-stream.Done():
+				return
+			}
+		}
+	}
+}
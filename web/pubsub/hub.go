@@ -0,0 +1,352 @@
+//Copyright (C) Mr.Pungle
+
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	POLICY_DROP  = iota // 订阅者缓冲区满时丢弃新消息
+	POLICY_BLOCK        // 订阅者缓冲区满时阻塞发布者, 直到有空间或超过BlockTimeout
+)
+
+var (
+	DEFAULT_BUFFER_SIZE  = 16
+	DEFAULT_HISTORY_SIZE = 0
+	DEFAULT_TOPIC_TTL    = 10 * time.Minute
+)
+
+var (
+	ErrHubClosed = errors.New("HubClosed")
+)
+
+type Message struct {
+	ID    uint64 // 同一个Hub内递增, 从1开始, topic被回收后重新创建也不会重复
+	Topic string
+	Data  interface{}
+	Time  time.Time
+}
+
+type Option func(*Hub)
+
+// 每个订阅者的缓冲区大小
+func BufferSize(size int) Option {
+	return func(h *Hub) {
+		h.bufferSize = size
+	}
+}
+
+// 每个topic保留的历史消息数, 用于断线后补发
+func HistorySize(size int) Option {
+	return func(h *Hub) {
+		h.historySize = size
+	}
+}
+
+func Policy(policy int) Option {
+	return func(h *Hub) {
+		h.policy = policy
+	}
+}
+
+// POLICY_BLOCK时发布者最长的等待时间, 超时后丢弃该订阅者的这条消息, 0表示一直等待
+func BlockTimeout(timeout time.Duration) Option {
+	return func(h *Hub) {
+		h.blockTimeout = timeout
+	}
+}
+
+// 没有订阅者的topic在最后一次发布或取消订阅ttl之后被回收, 历史消息一起删除,
+// 默认为DEFAULT_TOPIC_TTL, ttl<=0表示有历史消息的topic一直保留
+func TopicTTL(ttl time.Duration) Option {
+	return func(h *Hub) {
+		h.topicTTL = ttl
+	}
+}
+
+type Hub struct {
+	lastID uint64 // 放在最前面保证原子操作时64位对齐
+
+	bufferSize   int
+	historySize  int
+	policy       int
+	blockTimeout time.Duration
+	topicTTL     time.Duration
+
+	lock   sync.Mutex
+	topics map[string]*topic
+	closed bool
+	stop   chan struct{}
+}
+
+func NewHub(opts ...Option) *Hub {
+	hub := &Hub{
+		bufferSize:  DEFAULT_BUFFER_SIZE,
+		historySize: DEFAULT_HISTORY_SIZE,
+		policy:      POLICY_DROP,
+		topicTTL:    DEFAULT_TOPIC_TTL,
+		topics:      make(map[string]*topic),
+		stop:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(hub)
+	}
+	if hub.topicTTL > 0 {
+		go hub.janitor()
+	}
+	return hub
+}
+
+type topic struct {
+	name string
+
+	publishLock sync.Mutex // 保证每个订阅者收到的消息顺序一致
+
+	lock    sync.Mutex
+	active  time.Time // 最后一次发布或取消订阅的时间
+	history []*Message
+	subs    map[*Subscription]struct{}
+	dead    bool // 已经从Hub中移除, 需要重新获取
+}
+
+func (self *Hub) topic(name string, create bool) *topic {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return nil
+	}
+	t := self.topics[name]
+	if t == nil && create {
+		t = &topic{name: name, active: time.Now(), subs: make(map[*Subscription]struct{})}
+		self.topics[name] = t
+	}
+	return t
+}
+
+// 没有订阅者也没有历史消息的topic可以回收
+func (self *Hub) release(t *topic) {
+	self.lock.Lock()
+	t.lock.Lock()
+	if len(t.subs) == 0 && len(t.history) == 0 && self.topics[t.name] == t {
+		delete(self.topics, t.name)
+		t.dead = true
+	}
+	t.lock.Unlock()
+	self.lock.Unlock()
+}
+
+func (self *Hub) janitor() {
+	ticker := time.NewTicker(self.topicTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.gc()
+		case <-self.stop:
+			return
+		}
+	}
+}
+
+// 回收空闲超过topicTTL的topic, 即使还有历史消息
+func (self *Hub) gc() {
+	now := time.Now()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for name, t := range self.topics {
+		t.lock.Lock()
+		if len(t.subs) == 0 && now.Sub(t.active) >= self.topicTTL {
+			delete(self.topics, name)
+			t.dead = true
+		}
+		t.lock.Unlock()
+	}
+}
+
+func (self *Hub) Publish(name string, data interface{}) (*Message, error) {
+	var t *topic
+	for {
+		if t = self.topic(name, true); t == nil {
+			return nil, ErrHubClosed
+		}
+		t.publishLock.Lock()
+		t.lock.Lock()
+		if !t.dead {
+			break
+		}
+		t.lock.Unlock()
+		t.publishLock.Unlock()
+	}
+	defer t.publishLock.Unlock()
+
+	msg := &Message{atomic.AddUint64(&self.lastID, 1), name, data, time.Now()}
+	t.active = msg.Time
+	if self.historySize > 0 {
+		t.history = append(t.history, msg)
+		if len(t.history) > self.historySize {
+			t.history = append(t.history[:0:0], t.history[len(t.history)-self.historySize:]...)
+		}
+	}
+	subs := make([]*Subscription, 0, len(t.subs))
+	for sub := range t.subs {
+		subs = append(subs, sub)
+	}
+	t.lock.Unlock()
+
+	for _, sub := range subs {
+		sub.deliver(msg, self.policy, self.blockTimeout)
+	}
+	if len(subs) == 0 {
+		self.release(t)
+	}
+	return msg, nil
+}
+
+func (self *Hub) Subscribe(name string) (*Subscription, error) {
+	sub, _, err := self.subscribe(name, 0, false)
+	return sub, err
+}
+
+// 订阅并返回历史中ID大于lastID的消息, 返回的消息与之后收到的消息之间不会有遗漏或重复
+func (self *Hub) SubscribeFrom(name string, lastID uint64) (*Subscription, []*Message, error) {
+	return self.subscribe(name, lastID, true)
+}
+
+func (self *Hub) subscribe(name string, lastID uint64, catchUp bool) (*Subscription, []*Message, error) {
+	var t *topic
+	for {
+		if t = self.topic(name, true); t == nil {
+			return nil, nil, ErrHubClosed
+		}
+		t.lock.Lock()
+		if !t.dead {
+			break
+		}
+		t.lock.Unlock()
+	}
+	ch := make(chan *Message, self.bufferSize)
+	sub := &Subscription{C: ch, ch: ch, hub: self, topic: t, done: make(chan struct{})}
+
+	var missed []*Message
+	if catchUp {
+		missed = historyAfter(t.history, lastID)
+	}
+	t.subs[sub] = struct{}{}
+	t.lock.Unlock()
+	return sub, missed, nil
+}
+
+func historyAfter(history []*Message, lastID uint64) []*Message {
+	for idx, msg := range history {
+		if msg.ID > lastID {
+			result := make([]*Message, len(history)-idx)
+			copy(result, history[idx:])
+			return result
+		}
+	}
+	return nil
+}
+
+func (self *Hub) History(name string, lastID uint64) []*Message {
+	t := self.topic(name, false)
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return historyAfter(t.history, lastID)
+}
+
+func (self *Hub) Unsubscribe(sub *Subscription) {
+	sub.Close()
+}
+
+// 关闭所有订阅, 之后的Publish和Subscribe都会返回ErrHubClosed
+func (self *Hub) Close() {
+	self.lock.Lock()
+	if !self.closed {
+		close(self.stop)
+	}
+	self.closed = true
+	topics := self.topics
+	self.topics = make(map[string]*topic)
+	self.lock.Unlock()
+
+	for _, t := range topics {
+		t.lock.Lock()
+		subs := t.subs
+		t.subs = make(map[*Subscription]struct{})
+		t.lock.Unlock()
+		for sub := range subs {
+			sub.closeOnce.Do(func() { close(sub.done) })
+		}
+	}
+}
+
+//------------------ Subscription ------------------
+
+type Subscription struct {
+	C <-chan *Message
+
+	ch      chan *Message
+	hub     *Hub
+	topic   *topic
+	dropped uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (self *Subscription) Topic() string {
+	return self.topic.name
+}
+
+// 订阅被取消或Hub关闭后Done会被关闭, C不会被关闭
+func (self *Subscription) Done() <-chan struct{} {
+	return self.done
+}
+
+// 因为缓冲区满而被丢弃的消息数
+func (self *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+func (self *Subscription) deliver(msg *Message, policy int, timeout time.Duration) {
+	select {
+	case self.ch <- msg:
+		return
+	case <-self.done:
+		return
+	default:
+	}
+	if policy != POLICY_BLOCK {
+		atomic.AddUint64(&self.dropped, 1)
+		return
+	}
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+	select {
+	case self.ch <- msg:
+	case <-self.done:
+	case <-expire:
+		atomic.AddUint64(&self.dropped, 1)
+	}
+}
+
+func (self *Subscription) Close() {
+	t := self.topic
+	t.lock.Lock()
+	delete(t.subs, self)
+	t.active = time.Now()
+	t.lock.Unlock()
+	self.closeOnce.Do(func() { close(self.done) })
+	self.hub.release(t)
+}
@@ -0,0 +1,180 @@
+//Copyright (C) Mr.Pungle
+
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pungle/dawn/web"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	hub := NewHub()
+	sub, _ := hub.Subscribe("room")
+	other, _ := hub.Subscribe("other")
+	hub.Publish("room", "hello")
+
+	select {
+	case msg := <-sub.C:
+		if msg.ID != 1 || msg.Data != "hello" || msg.Topic != "room" {
+			t.Error("Message error:", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message not received")
+	}
+	if len(other.C) != 0 {
+		t.Error("Other topic should not receive message")
+	}
+
+	sub.Close()
+	hub.Publish("room", "bye")
+	if len(sub.C) != 0 {
+		t.Error("Closed subscription should not receive message")
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	hub := NewHub(BufferSize(2))
+	sub, _ := hub.Subscribe("room")
+	for i := 0; i < 5; i++ {
+		hub.Publish("room", i)
+	}
+	if len(sub.C) != 2 || sub.Dropped() != 3 {
+		t.Error("Drop policy error:", len(sub.C), sub.Dropped())
+	}
+}
+
+func TestBlockPolicy(t *testing.T) {
+	hub := NewHub(BufferSize(1), Policy(POLICY_BLOCK))
+	sub, _ := hub.Subscribe("room")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for i := 0; i < 10; i++ {
+			hub.Publish("room", i)
+		}
+		wg.Done()
+	}()
+	for i := 0; i < 10; i++ {
+		msg := <-sub.C
+		if msg.Data != i {
+			t.Error("Block policy order error:", msg.Data, i)
+		}
+	}
+	wg.Wait()
+	if sub.Dropped() != 0 {
+		t.Error("Block policy should not drop:", sub.Dropped())
+	}
+
+	hub = NewHub(BufferSize(1), Policy(POLICY_BLOCK), BlockTimeout(10*time.Millisecond))
+	sub, _ = hub.Subscribe("room")
+	hub.Publish("room", 1)
+	hub.Publish("room", 2)
+	if sub.Dropped() != 1 {
+		t.Error("Block timeout should drop message:", sub.Dropped())
+	}
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	hub := NewHub(HistorySize(3))
+	for i := 1; i <= 5; i++ {
+		hub.Publish("room", i)
+	}
+	sub, missed, _ := hub.SubscribeFrom("room", 3)
+	if len(missed) != 2 || missed[0].ID != 4 || missed[1].ID != 5 {
+		t.Error("Catch-up error:", missed)
+	}
+	hub.Publish("room", 6)
+	if msg := <-sub.C; msg.ID != 6 {
+		t.Error("Message after catch-up error:", msg.ID)
+	}
+	if history := hub.History("room", 0); len(history) != 3 || history[0].ID != 4 {
+		t.Error("History size error:", len(history))
+	}
+}
+
+func TestTopicTTL(t *testing.T) {
+	hub := NewHub(HistorySize(3), TopicTTL(20*time.Millisecond))
+	defer hub.Close()
+	hub.Publish("room", 1)
+	sub, _ := hub.Subscribe("other")
+	time.Sleep(100 * time.Millisecond)
+	hub.lock.Lock()
+	_, room := hub.topics["room"]
+	_, other := hub.topics["other"]
+	hub.lock.Unlock()
+	if room || !other {
+		t.Fatal("Idle topic with history should be released:", room, other)
+	}
+	sub.Close()
+
+	// 重新创建的topic中消息ID仍然递增
+	msg, _ := hub.Publish("room", 2)
+	if msg.ID != 2 || len(hub.History("room", 0)) != 1 {
+		t.Error("Message ID after topic recreated error:", msg.ID)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub()
+	sub, _ := hub.Subscribe("room")
+	hub.Close()
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("Subscription should be done after hub closed")
+	}
+	if _, err := hub.Publish("room", 1); err != ErrHubClosed {
+		t.Error("Publish after close error:", err)
+	}
+}
+
+func TestLongPollHandler(t *testing.T) {
+	hub := NewHub(HistorySize(10))
+	server := web.NewServer(web.NewHttpConfig(":0"), nil, &discardHandler{})
+	server.AddHandler("/poll", LongPollHandler(hub, QueryTopic("id"), time.Second))
+
+	hub.Publish("room", "a")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/poll?id=room&since=0", nil))
+	var messages []*pollMessage
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if len(messages) != 1 || messages[0].Data != "a" {
+		t.Error("Long poll history error:", resp.Body.String())
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		hub.Publish("room", "b")
+	}()
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", fmt.Sprintf("/poll?id=room&since=%d", messages[0].ID), nil))
+	messages = nil
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if len(messages) != 1 || messages[0].Data != "b" || messages[0].ID != 2 {
+		t.Error("Long poll wait error:", resp.Body.String())
+	}
+
+	// 没有since时不返回历史消息, 等待新消息
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		hub.Publish("room", "c")
+	}()
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/poll?id=room", nil))
+	messages = nil
+	json.Unmarshal(resp.Body.Bytes(), &messages)
+	if len(messages) != 1 || messages[0].Data != "c" {
+		t.Error("Long poll without since error:", resp.Body.String())
+	}
+}
+
+type discardHandler struct{}
+
+func (self *discardHandler) Write(b []byte) (int, error) {
+	return len(b), nil
+}
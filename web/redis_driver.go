@@ -3,64 +3,293 @@
 package web
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/garyburd/redigo/redis"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-type redisDriver struct {
-	pool *redis.Pool
+var (
+	DEFAULT_REDIS_CONNECT_TIMEOUT = 5 * time.Second
+	DEFAULT_REDIS_IO_TIMEOUT      = 3 * time.Second
+	DEFAULT_REDIS_HEALTH_CHECK    = time.Minute
+)
+
+var (
+	ErrRedisNotConnect = errors.New("RedisNotConnect")
+)
+
+// 版本号保存在versionKey中, 和数据使用相同的过期时间, 过期时间为0时不过期
+var setIfVersionScript = redis.NewScript(2, `
+local current = tonumber(redis.call('GET', KEYS[2]) or '0')
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	current = 0
+end
+if current ~= tonumber(ARGV[1]) then
+	return -1
+end
+current = current + 1
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SETEX', KEYS[1], ARGV[3], ARGV[2])
+	redis.call('SETEX', KEYS[2], ARGV[3], current)
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+	redis.call('SET', KEYS[2], current)
+end
+return current
+`)
+
+type RedisOptions struct {
+	Network string
+	Addr    string
+	// AUTH的密码和SELECT的数据库, 每个新连接建立时执行
+	Password string
+	DB       int
+
+	// key为Prefix+Namespace+":"+sessionID, Namespace为空时为Prefix+sessionID.
+	// 在Redis Cluster中使用时, Prefix里的{}只能用作完整的hash tag
+	Prefix    string
+	Namespace string
+
+	// 为0时使用DEFAULT_REDIS_CONNECT_TIMEOUT和DEFAULT_REDIS_IO_TIMEOUT, 小于0表示不限制
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	MaxIdle     int
+	MaxActive   int
+	IdleTimeout time.Duration
+	// 连接池满时等待空闲连接, 否则返回错误
+	Wait bool
+	// 空闲超过这个时间的连接在取出时先PING检查, 为0时使用DEFAULT_REDIS_HEALTH_CHECK, 小于0表示不检查
+	HealthCheckInterval time.Duration
 }
 
-func NewRedisSessionDriver(network string, addr string, maxIdle int,
-	maxActive int, idleTimeout time.Duration) SessionDriver {
+type RedisStats struct {
+	ActiveCount int
+	IdleCount   int
+	Commands    uint64
+	Errors      uint64
+}
+
+type RedisDriver struct {
+	pool   *redis.Pool
+	prefix string
+
+	commands uint64
+	errors   uint64
+}
+
+func timeoutOption(value time.Duration, defaultValue time.Duration) time.Duration {
+	if value == 0 {
+		return defaultValue
+	}
+	if value < 0 {
+		return 0
+	}
+	return value
+}
+
+func NewRedisDriver(opts RedisOptions) *RedisDriver {
+	network := opts.Network
+	if network == "" {
+		network = "tcp"
+	}
+	dialOptions := []redis.DialOption{
+		redis.DialConnectTimeout(timeoutOption(opts.ConnectTimeout, DEFAULT_REDIS_CONNECT_TIMEOUT)),
+		redis.DialReadTimeout(timeoutOption(opts.ReadTimeout, DEFAULT_REDIS_IO_TIMEOUT)),
+		redis.DialWriteTimeout(timeoutOption(opts.WriteTimeout, DEFAULT_REDIS_IO_TIMEOUT)),
+		redis.DialPassword(opts.Password),
+		redis.DialDatabase(opts.DB),
+	}
+	healthCheck := timeoutOption(opts.HealthCheckInterval, DEFAULT_REDIS_HEALTH_CHECK)
 
 	pool := &redis.Pool{
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial(network, addr)
-			if err != nil {
-				return nil, err
-			}
-			return c, nil
+			return redis.Dial(network, opts.Addr, dialOptions...)
 		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			r, err := redis.String(c.Do("PING"))
-			if r != "PONG" {
-				return errors.New("redis not connect")
+		MaxIdle:     opts.MaxIdle,
+		MaxActive:   opts.MaxActive,
+		IdleTimeout: opts.IdleTimeout,
+		Wait:        opts.Wait,
+	}
+	if healthCheck > 0 {
+		pool.TestOnBorrow = func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < healthCheck {
+				return nil
 			}
-			return err
-		},
+			return ping(c)
+		}
+	}
+
+	prefix := opts.Prefix
+	if opts.Namespace != "" {
+		prefix += opts.Namespace + ":"
+	}
+	return &RedisDriver{pool: pool, prefix: prefix}
+}
+
+// Deprecated: 使用NewRedisDriver
+func NewRedisSessionDriver(network string, addr string, maxIdle int,
+	maxActive int, idleTimeout time.Duration) SessionDriver {
+
+	return NewRedisDriver(RedisOptions{
+		Network:     network,
+		Addr:        addr,
 		MaxIdle:     maxIdle,
 		MaxActive:   maxActive,
 		IdleTimeout: idleTimeout,
+	})
+}
+
+func ping(c redis.Conn) error {
+	r, err := redis.String(c.Do("PING"))
+	if err != nil {
+		return err
 	}
-	return &redisDriver{pool}
+	if r != "PONG" {
+		return ErrRedisNotConnect
+	}
+	return nil
+}
+
+func (self *RedisDriver) key(key string) string {
+	return self.prefix + key
 }
 
-func (self *redisDriver) Get(key string) (interface{}, error) {
+// Redis Cluster要求同一个脚本或事务里的key在同一个slot, 而slot只按key中第一个{}里的内容计算.
+// 数据的key保持原样, 版本号的key把整个数据key作为hash tag; 数据的key已经带有hash tag时直接加后缀
+func (self *RedisDriver) versionKey(key string) string {
+	key = self.key(key)
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key + ":version"
+		}
+	}
+	return "{" + key + "}:version"
+}
+
+// 执行命令并记录统计信息
+func (self *RedisDriver) do(fn func(conn redis.Conn) error) error {
 	conn := self.pool.Get()
-	res, err := redis.Bytes(conn.Do("GET", key))
+	err := fn(conn)
 	conn.Close()
-	if err != nil {
-		return nil, err
+	atomic.AddUint64(&self.commands, 1)
+	if err != nil && err != redis.ErrNil {
+		atomic.AddUint64(&self.errors, 1)
+	}
+	return err
+}
+
+func (self *RedisDriver) Get(key string) ([]byte, error) {
+	var res []byte
+	err := self.do(func(conn redis.Conn) (err error) {
+		res, err = redis.Bytes(conn.Do("GET", self.key(key)))
+		return
+	})
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return res, err
+}
+
+// SETEX和EXPIRE的单位是秒, 不足1秒的部分向上取整. expire<=0表示不过期, 返回0
+func redisSeconds(expire time.Duration) int64 {
+	if expire <= 0 {
+		return 0
 	}
-	var result interface{}
-	err = json.Unmarshal(res, &result)
+	return int64((expire + time.Second - 1) / time.Second)
+}
+
+// expire<=0表示不过期, 和其它driver一致
+func (self *RedisDriver) Set(key string, value []byte, expire time.Duration) error {
+	seconds := redisSeconds(expire)
+	return self.do(func(conn redis.Conn) (err error) {
+		if seconds == 0 {
+			_, err = conn.Do("SET", self.key(key), value)
+		} else {
+			_, err = conn.Do("SETEX", self.key(key), seconds, value)
+		}
+		return
+	})
+}
+
+func (self *RedisDriver) GetVersion(key string) ([]byte, int64, error) {
+	var value []byte
+	var version int64
+	err := self.do(func(conn redis.Conn) error {
+		values, err := redis.Values(conn.Do("MGET", self.key(key), self.versionKey(key)))
+		if err != nil {
+			return err
+		}
+		_, err = redis.Scan(values, &value, &version)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	if value == nil {
+		return nil, 0, nil
 	}
-	return result, err
+	return value, version, nil
 }
 
-func (self *redisDriver) Set(key string, value interface{}, expire time.Duration) error {
-	encodeData, err := json.Marshal(value)
+func (self *RedisDriver) SetIfVersion(key string, value []byte, version int64, expire time.Duration) (int64, error) {
+	var result int64
+	err := self.do(func(conn redis.Conn) (err error) {
+		result, err = redis.Int64(setIfVersionScript.Do(conn, self.key(key), self.versionKey(key),
+			version, value, redisSeconds(expire)))
+		return
+	})
 	if err != nil {
+		return 0, err
+	}
+	if result < 0 {
+		return 0, ErrSessionConflict
+	}
+	return result, nil
+}
+
+func (self *RedisDriver) Delete(key string) error {
+	return self.do(func(conn redis.Conn) error {
+		_, err := conn.Do("DEL", self.key(key), self.versionKey(key))
+		return err
+	})
+}
+
+func (self *RedisDriver) Touch(key string, expire time.Duration) error {
+	seconds := redisSeconds(expire)
+	return self.do(func(conn redis.Conn) error {
+		conn.Send("MULTI")
+		if seconds == 0 {
+			conn.Send("PERSIST", self.key(key))
+			conn.Send("PERSIST", self.versionKey(key))
+		} else {
+			conn.Send("EXPIRE", self.key(key), seconds)
+			conn.Send("EXPIRE", self.versionKey(key), seconds)
+		}
+		_, err := conn.Do("EXEC")
 		return err
+	})
+}
+
+// 健康检查, 可以用于负载均衡的探活接口
+func (self *RedisDriver) Ping() error {
+	return self.do(ping)
+}
+
+func (self *RedisDriver) Stats() RedisStats {
+	stats := self.pool.Stats()
+	return RedisStats{
+		ActiveCount: stats.ActiveCount,
+		IdleCount:   stats.IdleCount,
+		Commands:    atomic.LoadUint64(&self.commands),
+		Errors:      atomic.LoadUint64(&self.errors),
 	}
-	conn := self.pool.Get()
-	_, err = conn.Do("SETEX", key, int(expire/time.Second), encodeData)
-	conn.Close()
-	return err
+}
 
+// 关闭连接池, 之后的操作都会返回错误
+func (self *RedisDriver) Close() error {
+	return self.pool.Close()
 }
@@ -0,0 +1,277 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 只实现了driver用到的命令的Redis协议服务, EVAL直接按setIfVersionScript的逻辑处理
+type fakeRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	data     map[string]string
+	commands []string
+	password string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{listener: listener, data: make(map[string]string), password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (self *fakeRedis) Close() {
+	self.listener.Close()
+}
+
+func (self *fakeRedis) history() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return strings.Join(self.commands, "\n")
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(value string, ok bool) string {
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func (self *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := self.password == ""
+	var queued []string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		self.lock.Lock()
+		self.commands = append(self.commands, strings.Join(args, " "))
+		name := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case name == "AUTH":
+			authed = args[1] == self.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-ERR invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case name == "MULTI":
+			inMulti = true
+			reply = "+OK\r\n"
+		case name == "EXEC":
+			inMulti = false
+			reply = fmt.Sprintf("*%d\r\n%s", len(queued), strings.Join(queued, ""))
+			queued = nil
+		default:
+			reply = self.exec(name, args[1:])
+			if inMulti {
+				queued = append(queued, reply)
+				reply = "+QUEUED\r\n"
+			}
+		}
+		self.lock.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func (self *fakeRedis) exec(name string, args []string) string {
+	switch name {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := self.data[args[0]]
+		return bulk(value, ok)
+	case "SETEX":
+		if args[1] == "0" {
+			return "-ERR invalid expire time in 'setex' command\r\n"
+		}
+		self.data[args[0]] = args[2]
+		return "+OK\r\n"
+	case "SET":
+		self.data[args[0]] = args[1]
+		return "+OK\r\n"
+	case "MGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args))
+		for _, key := range args {
+			value, ok := self.data[key]
+			reply += bulk(value, ok)
+		}
+		return reply
+	case "DEL":
+		var n int
+		for _, key := range args {
+			if _, ok := self.data[key]; ok {
+				delete(self.data, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "EXPIRE", "PERSIST":
+		return ":1\r\n"
+	case "EVALSHA":
+		return "-NOSCRIPT No matching script.\r\n"
+	case "EVAL":
+		key, versionKey := args[2], args[3]
+		current, _ := strconv.ParseInt(self.data[versionKey], 10, 64)
+		if _, ok := self.data[key]; !ok {
+			current = 0
+		}
+		if expected, _ := strconv.ParseInt(args[4], 10, 64); expected != current {
+			return ":-1\r\n"
+		}
+		current++
+		self.data[key] = args[5]
+		self.data[versionKey] = strconv.FormatInt(current, 10)
+		return fmt.Sprintf(":%d\r\n", current)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisDriver(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	defer server.Close()
+	driver := NewRedisDriver(RedisOptions{
+		Addr:      server.listener.Addr().String(),
+		Password:  "secret",
+		DB:        2,
+		Prefix:    "dawn:",
+		Namespace: "admin",
+		MaxIdle:   2,
+	})
+	defer driver.Close()
+
+	if err := driver.Ping(); err != nil {
+		t.Fatal("Ping error:", err)
+	}
+	if err := driver.Set("sid", []byte("data"), time.Hour); err != nil {
+		t.Fatal("Set error:", err)
+	}
+	value, err := driver.Get("sid")
+	if err != nil || string(value) != "data" {
+		t.Error("Get error:", string(value), err)
+	}
+	if value, err := driver.Get("none"); value != nil || err != nil {
+		t.Error("Unknown key should return nil:", value, err)
+	}
+	history := server.history()
+	if !strings.Contains(history, "AUTH secret") || !strings.Contains(history, "SELECT 2") ||
+		!strings.Contains(history, "SETEX dawn:admin:sid 3600 data") {
+		t.Error("Command error:", history)
+	}
+
+	version, err := driver.SetIfVersion("v", []byte("1"), 0, time.Hour)
+	if err != nil || version != 1 {
+		t.Error("SetIfVersion error:", version, err)
+	}
+	if _, err := driver.SetIfVersion("v", []byte("2"), 0, time.Hour); err != ErrSessionConflict {
+		t.Error("SetIfVersion should conflict:", err)
+	}
+	if history := server.history(); !strings.Contains(history, "dawn:admin:v {dawn:admin:v}:version 0 1 3600") {
+		t.Error("Version key should share the hash slot with data key:", history)
+	}
+	value, version, err = driver.GetVersion("v")
+	if err != nil || string(value) != "1" || version != 1 {
+		t.Error("GetVersion error:", string(value), version, err)
+	}
+	if err := driver.Touch("v", time.Hour); err != nil {
+		t.Error("Touch error:", err)
+	}
+	if err := driver.Set("short", []byte("data"), 500*time.Millisecond); err != nil {
+		t.Error("Sub-second expire error:", err)
+	}
+	if history := server.history(); !strings.Contains(history, "SETEX dawn:admin:short 1 data") {
+		t.Error("Expire should be rounded up:", history)
+	}
+	// expire<=0表示不过期, 和其它driver一致
+	driver.Set("forever", []byte("data"), 0)
+	driver.SetIfVersion("w", []byte("1"), 0, 0)
+	driver.Touch("w", 0)
+	history = server.history()
+	if !strings.Contains(history, "SET dawn:admin:forever data") ||
+		!strings.Contains(history, "dawn:admin:w {dawn:admin:w}:version 0 1 0") ||
+		!strings.Contains(history, "PERSIST dawn:admin:w\nPERSIST {dawn:admin:w}:version") {
+		t.Error("Zero expire should not set a TTL:", history)
+	}
+	driver.Delete("v")
+	if value, version, _ := driver.GetVersion("v"); value != nil || version != 0 {
+		t.Error("Delete error:", value, version)
+	}
+
+	stats := driver.Stats()
+	if stats.Commands == 0 || stats.Errors != 0 || stats.IdleCount != 1 {
+		t.Error("Stats error:", stats)
+	}
+	driver.Close()
+	if driver.Ping() == nil {
+		t.Error("Ping should fail after close")
+	}
+}
+
+func TestRedisVersionKey(t *testing.T) {
+	driver := NewRedisDriver(RedisOptions{Prefix: "{session}:"})
+	defer driver.Close()
+	if key := driver.versionKey("sid"); key != "{session}:sid:version" {
+		t.Error("Version key should keep the existing hash tag:", key)
+	}
+}
+
+func TestRedisDriverAuthError(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	defer server.Close()
+	driver := NewRedisDriver(RedisOptions{Addr: server.listener.Addr().String(), Password: "wrong"})
+	defer driver.Close()
+	if driver.Ping() == nil || driver.Stats().Errors != 1 {
+		t.Error("Wrong password should fail")
+	}
+}
@@ -0,0 +1,308 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseInfo记录响应的状态, 可以在handler返回后通过ctx.ResponseInfo()读取
+type ResponseInfo struct {
+	start       time.Time
+	firstByte   time.Time
+	status      int
+	written     int64
+	wroteHeader bool
+	hijacked    bool
+
+	// 在写入响应头之前调用, 例如自动保存session并设置cookie
+	beforeHeader []func(http.ResponseWriter)
+}
+
+// 响应状态码, handler没有调用WriteHeader时为200, 连接被接管时为101
+func (self *ResponseInfo) Status() int {
+	return self.status
+}
+
+func (self *ResponseInfo) BytesWritten() int64 {
+	return self.written
+}
+
+// 从开始处理请求到写入第一个字节的时间, 还没有写入时返回0
+func (self *ResponseInfo) TimeToFirstByte() time.Duration {
+	if self.firstByte.IsZero() {
+		return 0
+	}
+	return self.firstByte.Sub(self.start)
+}
+
+func (self *ResponseInfo) Duration() time.Duration {
+	return time.Since(self.start)
+}
+
+func (self *ResponseInfo) HeaderWritten() bool {
+	return self.wroteHeader
+}
+
+func (self *ResponseInfo) Hijacked() bool {
+	return self.hijacked
+}
+
+func (self *ResponseInfo) onBeforeHeader(fn func(http.ResponseWriter)) {
+	self.beforeHeader = append(self.beforeHeader, fn)
+}
+
+// 执行并清空beforeHeader回调
+func (self *ResponseInfo) runBeforeHeader(w http.ResponseWriter) {
+	hooks := self.beforeHeader
+	self.beforeHeader = nil
+	for _, fn := range hooks {
+		fn(w)
+	}
+}
+
+func (self *ResponseInfo) markFirstByte() {
+	if self.firstByte.IsZero() {
+		self.firstByte = time.Now()
+	}
+}
+
+//------------------ responseWriter ------------------
+
+type responseWriter struct {
+	http.ResponseWriter
+	info *ResponseInfo
+}
+
+type infoWriter interface {
+	responseInfo() *ResponseInfo
+}
+
+// 包装w并记录响应状态, 返回的ResponseWriter只实现w本身支持的
+// http.Flusher, http.Hijacker, http.Pusher和io.ReaderFrom
+func newResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *ResponseInfo) {
+	info := &ResponseInfo{start: time.Now(), status: http.StatusOK}
+	rw := &responseWriter{w, info}
+
+	const (
+		flush = 1 << iota
+		hijack
+		push
+		readFrom
+	)
+	var mask int
+	f, ok := w.(http.Flusher)
+	if ok {
+		mask |= flush
+	}
+	h, ok := w.(http.Hijacker)
+	if ok {
+		mask |= hijack
+	}
+	p, ok := w.(http.Pusher)
+	if ok {
+		mask |= push
+	}
+	r, ok := w.(io.ReaderFrom)
+	if ok {
+		mask |= readFrom
+	}
+	rf := &rwFlusher{rw, f}
+	rh := &rwHijacker{rw, h}
+	rp := &rwPusher{p}
+	rr := &rwReaderFrom{rw, r}
+
+	switch mask {
+	case flush:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{rw, rf}, info
+	case hijack:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{rw, rh}, info
+	case flush | hijack:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, rf, rh}, info
+	case push:
+		return struct {
+			*responseWriter
+			http.Pusher
+		}{rw, rp}, info
+	case flush | push:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+		}{rw, rf, rp}, info
+	case hijack | push:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+		}{rw, rh, rp}, info
+	case flush | hijack | push:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, rf, rh, rp}, info
+	case readFrom:
+		return struct {
+			*responseWriter
+			io.ReaderFrom
+		}{rw, rr}, info
+	case flush | readFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{rw, rf, rr}, info
+	case hijack | readFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, rh, rr}, info
+	case flush | hijack | readFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, rf, rh, rr}, info
+	case push | readFrom:
+		return struct {
+			*responseWriter
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rp, rr}, info
+	case flush | push | readFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rf, rp, rr}, info
+	case hijack | push | readFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rh, rp, rr}, info
+	case flush | hijack | push | readFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rf, rh, rp, rr}, info
+	}
+	return rw, info
+}
+
+func (self *responseWriter) responseInfo() *ResponseInfo {
+	return self.info
+}
+
+// 供http.ResponseController使用
+func (self *responseWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
+
+func (self *responseWriter) WriteHeader(code int) {
+	self.writeHeader(code, true)
+}
+
+func (self *responseWriter) writeHeader(code int, runHooks bool) {
+	info := self.info
+	if info.wroteHeader || info.hijacked {
+		return
+	}
+	// 1xx(除了101)不是最终的响应
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		self.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if runHooks {
+		info.runBeforeHeader(self)
+	}
+	info.status = code
+	info.wroteHeader = true
+	self.ResponseWriter.WriteHeader(code)
+}
+
+func (self *responseWriter) Write(value []byte) (int, error) {
+	if !self.info.wroteHeader {
+		self.WriteHeader(http.StatusOK)
+	}
+	self.info.markFirstByte()
+	n, err := self.ResponseWriter.Write(value)
+	self.info.written += int64(n)
+	return n, err
+}
+
+type rwFlusher struct {
+	rw *responseWriter
+	f  http.Flusher
+}
+
+func (self *rwFlusher) Flush() {
+	if !self.rw.info.wroteHeader {
+		self.rw.WriteHeader(http.StatusOK)
+	}
+	self.rw.info.markFirstByte()
+	self.f.Flush()
+}
+
+type rwHijacker struct {
+	rw *responseWriter
+	h  http.Hijacker
+}
+
+func (self *rwHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := self.h.Hijack()
+	if err == nil {
+		info := self.rw.info
+		info.hijacked = true
+		if !info.wroteHeader {
+			info.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, buf, err
+}
+
+type rwPusher struct {
+	p http.Pusher
+}
+
+func (self *rwPusher) Push(target string, opts *http.PushOptions) error {
+	return self.p.Push(target, opts)
+}
+
+type rwReaderFrom struct {
+	rw *responseWriter
+	r  io.ReaderFrom
+}
+
+func (self *rwReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	if !self.rw.info.wroteHeader {
+		self.rw.WriteHeader(http.StatusOK)
+	}
+	self.rw.info.markFirstByte()
+	n, err := self.r.ReadFrom(src)
+	self.rw.info.written += n
+	return n, err
+}
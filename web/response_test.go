@@ -0,0 +1,86 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fullResponseWriter struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+	readFrom bool
+}
+
+func (self *fullResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	self.hijacked = true
+	return nil, nil, nil
+}
+
+func (self *fullResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return nil
+}
+
+func (self *fullResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	self.readFrom = true
+	return io.Copy(self.ResponseRecorder.Body, src)
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	resp, _ := newResponseWriter(httptest.NewRecorder())
+	if _, ok := resp.(http.Flusher); !ok {
+		t.Error("Wrapper should keep http.Flusher")
+	}
+	if _, ok := resp.(http.Hijacker); ok {
+		t.Error("Wrapper should not expose http.Hijacker")
+	}
+	if _, ok := resp.(io.ReaderFrom); ok {
+		t.Error("Wrapper should not expose io.ReaderFrom")
+	}
+
+	full := &fullResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	resp, info := newResponseWriter(full)
+	_, isFlusher := resp.(http.Flusher)
+	_, isPusher := resp.(http.Pusher)
+	readerFrom, isReaderFrom := resp.(io.ReaderFrom)
+	hijacker, isHijacker := resp.(http.Hijacker)
+	if !isFlusher || !isPusher || !isReaderFrom || !isHijacker {
+		t.Fatal("Wrapper should expose all interfaces", isFlusher, isPusher, isReaderFrom, isHijacker)
+	}
+	n, _ := readerFrom.ReadFrom(strings.NewReader("hello"))
+	if !full.readFrom || n != 5 || info.BytesWritten() != 5 || !info.HeaderWritten() {
+		t.Error("ReadFrom error:", full.readFrom, n, info.BytesWritten())
+	}
+	hijacker.Hijack()
+	if !full.hijacked || !info.Hijacked() {
+		t.Error("Hijack error")
+	}
+}
+
+func TestResponseInfo(t *testing.T) {
+	server := newTestServer()
+	var info *ResponseInfo
+	server.AddHandler("/", func(ctx *HttpContext) {
+		info = ctx.ResponseInfo()
+		if info.HeaderWritten() {
+			t.Error("Header should not be written yet")
+		}
+		ctx.Response.WriteHeader(http.StatusCreated)
+		ctx.Response.WriteHeader(http.StatusAccepted)
+		ctx.Response.Write([]byte("created"))
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	if info.Status() != http.StatusCreated || info.BytesWritten() != 7 || !info.HeaderWritten() {
+		t.Error("ResponseInfo error:", info.Status(), info.BytesWritten(), info.HeaderWritten())
+	}
+	if info.TimeToFirstByte() <= 0 {
+		t.Error("TimeToFirstByte should be recorded")
+	}
+}
@@ -0,0 +1,113 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net/http"
+	"time"
+)
+
+type RouteOption func(*route)
+
+// 为单个路由设置处理超时, 覆盖HttpConfig中的全局设置, timeout<=0表示不限制
+func RouteTimeout(timeout time.Duration) RouteOption {
+	return func(r *route) {
+		r.timeout = timeout
+		r.hasTimeout = true
+	}
+}
+
+// 为单个路由设置请求体的最大字节数, 覆盖HttpConfig中的全局设置, size<=0表示不限制
+func RouteMaxBodySize(size int64) RouteOption {
+	return func(r *route) {
+		r.maxBodySize = size
+		r.hasMaxBodySize = true
+	}
+}
+
+func RouteUploadLimits(limits UploadLimits) RouteOption {
+	return func(r *route) {
+		r.limits = &limits
+	}
+}
+
+// 路由使用通过HttpServer.AddSessionContext注册的SessionContext, ctx.Session()等方法都会使用它.
+// name没有注册时AddHandler返回ErrSessionNotSetup
+func RouteSession(name string) RouteOption {
+	return func(r *route) {
+		r.sessionName = name
+	}
+}
+
+type route struct {
+	server  *HttpServer
+	pattern string
+	handler Handler
+
+	timeout    time.Duration
+	hasTimeout bool
+
+	maxBodySize    int64
+	hasMaxBodySize bool
+	limits         *UploadLimits
+
+	sessionName string
+
+	ws wsOptions
+}
+
+func newRoute(server *HttpServer, pattern string, handler Handler, opts []RouteOption) *route {
+	r := &route{server: server, pattern: pattern, handler: handler}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (self *route) uploadLimits() UploadLimits {
+	if self.limits != nil {
+		return *self.limits
+	}
+	return self.server.config.uploadLimits
+}
+
+func (self *route) serve(ctx *HttpContext) {
+	config := self.server.config
+	ctx.route = self
+	if self.sessionName != "" {
+		ctx.sessionCtx = self.server.sessions[self.sessionName]
+	}
+
+	maxBodySize := config.maxBodySize
+	if self.hasMaxBodySize {
+		maxBodySize = self.maxBodySize
+	}
+	if maxBodySize > 0 {
+		if ctx.Request.ContentLength > maxBodySize {
+			ctx.bodyTooLarge()
+			return
+		}
+		ctx.body = &limitedBody{ReadCloser: http.MaxBytesReader(ctx.Response, ctx.Request.Body, maxBodySize)}
+		ctx.Request.Body = ctx.body
+	}
+
+	timeout := config.handlerTimeout
+	if self.hasTimeout {
+		timeout = self.timeout
+	}
+	if timeout <= 0 {
+		self.invoke(ctx)
+		return
+	}
+	serveWithTimeout(ctx, self.invoke, timeout, config.timeoutStatus)
+}
+
+func (self *route) invoke(ctx *HttpContext) {
+	defer ctx.cleanup()
+	self.handler(ctx)
+	// chunked或长度未知的请求体在读取时才会发现超过限制
+	if ctx.body != nil && ctx.body.exceeded && !ctx.headerWritten() {
+		ctx.bodyTooLarge()
+	}
+	ctx.finishSession()
+}
@@ -3,7 +3,9 @@
 package web
 
 import (
+	"bytes"
 	"github.com/pungle/dawn/logging"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -22,42 +24,19 @@ type Resolver interface {
 	Resolve(string) (Handler, map[string]string)
 }
 
-type HttpConfig struct {
-	addr     string
-	tls      bool
-	certfile string
-	keyfile  string
-
-	logFlag  int
-	logLevel int
-}
-
-func NewConfig(addr string, logFlag int, logLevel int,
-	tls bool, certfile string, keyfile string) *HttpConfig {
-	return &HttpConfig{addr, tls, certfile, keyfile, logFlag, logLevel}
-}
-
-type loggedResponseWriter struct {
-	http.ResponseWriter
-	status        int
-	contentLength int
-}
-
-func (self *loggedResponseWriter) WriteHeader(code int) {
-	self.status = code
-	self.ResponseWriter.WriteHeader(code)
-}
-
-func (self *loggedResponseWriter) Write(value []byte) (int, error) {
-	self.contentLength += len(value)
-	return self.ResponseWriter.Write(value)
-}
-
 type HttpServer struct {
 	config     *HttpConfig
 	resolvers  []Resolver
 	sessionCtx *SessionContext
+	sessions   map[string]*SessionContext
 	logger     *logging.Logger
+	accessLog  accessFormatter
+
+	trustedProxies []*net.IPNet
+
+	// ctx.SetCookie签名和加密使用的key
+	cookieSigner *hmacSigner
+	cookieCipher *CookieStore
 }
 
 func NewServer(config *HttpConfig, sessionCtx *SessionContext, logHandler logging.Handler) *HttpServer {
@@ -70,10 +49,23 @@ func NewServer(config *HttpConfig, sessionCtx *SessionContext, logHandler loggin
 		logHandler = os.Stderr
 	}
 	logger := logging.NewLogger(logHandler, config.logFlag, config.logLevel)
-	return &HttpServer{config, resolvers, sessionCtx, logger}
+	accessLog := compileAccessFormat(config.accessFormat)
+	return &HttpServer{config: config, resolvers: resolvers, sessionCtx: sessionCtx,
+		sessions: make(map[string]*SessionContext), logger: logger, accessLog: accessLog}
 }
 
-func (self *HttpServer) AddHandler(urlPattern string, handler Handler) (err error) {
+// 注册命名的SessionContext, 路由通过RouteSession绑定, handler中也可以通过ctx.SessionNamed使用.
+// 需要在使用它的路由注册之前注册
+func (self *HttpServer) AddSessionContext(name string, sessionCtx *SessionContext) {
+	self.sessions[name] = sessionCtx
+}
+
+// 访问日志使用的logger, 和全局的logging分开, 可以单独调整级别
+func (self *HttpServer) AccessLogger() *logging.Logger {
+	return self.logger
+}
+
+func (self *HttpServer) AddHandler(urlPattern string, handler Handler, opts ...RouteOption) (err error) {
 	flag := urlPattern[0]
 	var resolverIndex int = 0
 	var pattern = urlPattern
@@ -90,25 +82,46 @@ func (self *HttpServer) AddHandler(urlPattern string, handler Handler) (err erro
 		break
 	}
 	resolver := self.resolvers[resolverIndex]
-	resolver.AddHandler(pattern, handler)
-	return
+	r := newRoute(self, urlPattern, handler, opts)
+	if r.sessionName != "" {
+		if _, ok := self.sessions[r.sessionName]; !ok {
+			return ErrSessionNotSetup
+		}
+	}
+	return resolver.AddHandler(pattern, r.serve)
 }
 
-func (self *HttpServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	loggedResp := &loggedResponseWriter{resp, http.StatusOK, 0}
+func (self *HttpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	resp, info := newResponseWriter(w)
+	client := self.resolveClient(req)
+	entry := &accessEntry{req: req, resp: resp, info: info, requestID: requestID(req), clientIP: client.ip}
+	resp.Header().Set(REQUEST_ID_HEADER, entry.requestID)
+	var body *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &countingBody{ReadCloser: req.Body}
+		req.Body = body
+	}
 	for _, resolver := range self.resolvers {
 		handler, vars := resolver.Resolve(req.URL.Path)
 		if handler != nil {
-			ctx := NewHttpContext(loggedResp, req, self.sessionCtx, vars)
-			loggedResp.Header().Set("Content-Type", "application/json")
+			ctx := NewHttpContext(resp, req, self.sessionCtx, vars)
+			ctx.requestID = entry.requestID
+			ctx.client = client
+			resp.Header().Set("Content-Type", "application/json")
 			handler(ctx)
+			if ctx.route != nil {
+				entry.pattern = ctx.route.pattern
+			}
 			goto logTime
 		}
 	}
-	http.NotFound(loggedResp, req)
+	http.NotFound(resp, req)
 
 logTime:
-	self.writeLog(loggedResp, req)
+	if body != nil {
+		entry.bytesIn = body.count()
+	}
+	self.writeLog(entry)
 }
 
 func (self *HttpServer) ListenAndServe() {
@@ -122,29 +135,27 @@ func (self *HttpServer) listen() {
 	logging.Notify("Listening %s", self.config.addr)
 	logging.Notify("Https: %v", self.config.tls)
 	config := self.config
-	var err error
+	server := config.newServer(self)
+	listener, err := net.Listen("tcp", config.addr)
+	if err != nil {
+		logging.Error("Listen has an error: %s", err.Error())
+		return
+	}
+	if config.maxConns > 0 {
+		listener = newLimitListener(listener, config.maxConns)
+	}
 	if config.tls {
-		err = http.ListenAndServeTLS(config.addr, config.certfile, config.keyfile, self)
+		err = server.ServeTLS(listener, config.certfile, config.keyfile)
 	} else {
-		err = http.ListenAndServe(config.addr, self)
+		err = server.Serve(listener)
 	}
 	if err != nil {
 		logging.Error("Listen has an error: %s", err.Error())
 	}
 }
 
-func (self *HttpServer) writeLog(resp *loggedResponseWriter, req *http.Request) {
-	header := resp.Header()
-	self.logger.Info(
-		"[%s] %s%s %d %d '%s' '%s' '%s' '%s'",
-		req.Method,
-		req.Host,
-		req.RequestURI,
-		resp.status,
-		resp.contentLength,
-		header.Get("Content-Type"),
-		req.RemoteAddr,
-		req.UserAgent(),
-		req.Referer(),
-	)
+func (self *HttpServer) writeLog(entry *accessEntry) {
+	var buf bytes.Buffer
+	self.accessLog(&buf, entry)
+	self.logger.Log(accessLevel(entry.info.Status()), "%s", buf.String())
 }
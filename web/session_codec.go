@@ -0,0 +1,318 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"github.com/vmihailenco/msgpack"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionKeyNotFound = errors.New("SessionKeyNotFound")
+	ErrSessionValueType   = errors.New("SessionValueTypeMismatch")
+)
+
+// session数据的编码方式, 编码后的数据由SessionDriver保存
+type SessionCodec interface {
+	Encode(data map[string]interface{}) ([]byte, error)
+	Decode(content []byte) (map[string]interface{}, error)
+}
+
+var (
+	// 每个值都会带上类型名, 注册过的类型解码后保持原来的类型, 没有注册的类型按encoding/json的规则解码
+	JSONSessionCodec SessionCodec = &typedCodec{marshalJSON, json.Unmarshal, true}
+	// 同JSONSessionCodec, 使用MessagePack编码
+	MsgpackSessionCodec SessionCodec = &typedCodec{msgpack.Marshal, msgpack.Unmarshal, false}
+	// 使用encoding/gob编码, 自定义类型需要通过RegisterSessionType注册
+	GobSessionCodec SessionCodec = &gobCodec{}
+
+	DEFAULT_SESSION_CODEC = JSONSessionCodec
+)
+
+var (
+	sessionTypeLock  sync.RWMutex
+	sessionTypes     = make(map[string]reflect.Type)
+	sessionTypeNames = make(map[reflect.Type]string)
+)
+
+func init() {
+	for _, value := range []interface{}{
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0), false, "", []byte(nil),
+		[]int(nil), []int64(nil), []string(nil), []interface{}(nil),
+		map[string]string(nil), map[string]int(nil), map[string]interface{}(nil),
+		time.Time{}, time.Duration(0),
+	} {
+		RegisterSessionType(value)
+	}
+}
+
+// 注册保存在session中的自定义类型, 注册后取出的值和保存时的类型相同.
+// 同时会注册到encoding/gob中
+func RegisterSessionType(value interface{}) {
+	t := reflect.TypeOf(value)
+	sessionTypeLock.Lock()
+	sessionTypes[t.String()] = t
+	sessionTypeNames[t] = t.String()
+	sessionTypeLock.Unlock()
+	gob.Register(value)
+}
+
+func sessionTypeName(value interface{}) string {
+	sessionTypeLock.RLock()
+	defer sessionTypeLock.RUnlock()
+	return sessionTypeNames[reflect.TypeOf(value)]
+}
+
+func sessionType(name string) reflect.Type {
+	sessionTypeLock.RLock()
+	defer sessionTypeLock.RUnlock()
+	return sessionTypes[name]
+}
+
+//------------------ typedCodec ------------------
+
+type typedCodec struct {
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte, interface{}) error
+	json      bool
+}
+
+type typedValue struct {
+	Type  string `json:"t" msgpack:"t"`
+	Value []byte `json:"v" msgpack:"v"`
+}
+
+type jsonTypedValue struct {
+	Type  string          `json:"t"`
+	Value json.RawMessage `json:"v"`
+}
+
+// JSON中直接嵌入原始数据, 避免[]byte被编码为base64
+func marshalJSON(v interface{}) ([]byte, error) {
+	values, ok := v.(map[string]*typedValue)
+	if !ok {
+		return json.Marshal(v)
+	}
+	result := make(map[string]*jsonTypedValue, len(values))
+	for key, value := range values {
+		result[key] = &jsonTypedValue{value.Type, value.Value}
+	}
+	return json.Marshal(result)
+}
+
+func (self *typedCodec) Encode(data map[string]interface{}) ([]byte, error) {
+	values := make(map[string]*typedValue, len(data))
+	for key, value := range data {
+		encoded, err := self.marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = &typedValue{sessionTypeName(value), encoded}
+	}
+	return self.marshal(values)
+}
+
+// 每个值都是只有t和v两个字段的对象时才是带类型的格式
+func jsonTypedValues(raw map[string]json.RawMessage) (map[string]*typedValue, bool) {
+	values := make(map[string]*typedValue, len(raw))
+	for key, content := range raw {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(content, &fields) != nil || len(fields) != 2 || fields["v"] == nil {
+			return nil, false
+		}
+		var value typedValue
+		if json.Unmarshal(fields["t"], &value.Type) != nil {
+			return nil, false
+		}
+		value.Value = fields["v"]
+		values[key] = &value
+	}
+	return values, true
+}
+
+func (self *typedCodec) Decode(content []byte) (map[string]interface{}, error) {
+	var values map[string]*typedValue
+	if self.json {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return nil, err
+		}
+		var ok bool
+		if values, ok = jsonTypedValues(raw); !ok {
+			// 旧版本直接保存map的JSON, 值按encoding/json的规则解码
+			var data map[string]interface{}
+			if err := json.Unmarshal(content, &data); err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+	} else if err := self.unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if value == nil {
+			continue
+		}
+		if t := sessionType(value.Type); t != nil {
+			ptr := reflect.New(t)
+			if err := self.unmarshal(value.Value, ptr.Interface()); err != nil {
+				return nil, err
+			}
+			data[key] = ptr.Elem().Interface()
+			continue
+		}
+		var result interface{}
+		if err := self.unmarshal(value.Value, &result); err != nil {
+			return nil, err
+		}
+		data[key] = result
+	}
+	return data, nil
+}
+
+//------------------ gobCodec ------------------
+
+type gobCodec struct{}
+
+func (self *gobCodec) Encode(data map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (self *gobCodec) Decode(content []byte) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+//------------------ typed getters ------------------
+
+func (self *httpSession) value(key string) (interface{}, error) {
+	if self.data == nil {
+		return nil, SessionNotInitErr
+	}
+	value, ok := self.data[key]
+	if !ok {
+		return nil, ErrSessionKeyNotFound
+	}
+	return value, nil
+}
+
+func (self *httpSession) GetInt(key string) (int, error) {
+	value, err := self.GetInt64(key)
+	if err != nil {
+		return 0, err
+	}
+	// 32位平台上int放不下的值
+	if int64(int(value)) != value {
+		return 0, ErrSessionValueType
+	}
+	return int(value), nil
+}
+
+// 整数和没有小数部分的浮点数都可以转换, 超出int64范围时返回ErrSessionValueType
+func (self *httpSession) GetInt64(key string) (int64, error) {
+	value, err := self.value(key)
+	if err != nil {
+		return 0, err
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		// 超出范围的浮点数转换为int64的结果依赖平台, 需要先检查范围
+		if f := rv.Float(); f >= math.MinInt64 && f < math.MaxInt64 && f == math.Trunc(f) {
+			return int64(f), nil
+		}
+	}
+	return 0, ErrSessionValueType
+}
+
+func (self *httpSession) GetFloat64(key string) (float64, error) {
+	value, err := self.value(key)
+	if err != nil {
+		return 0, err
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, ErrSessionValueType
+}
+
+func (self *httpSession) GetString(key string) (string, error) {
+	value, err := self.value(key)
+	if err != nil {
+		return "", err
+	}
+	result, ok := value.(string)
+	if !ok {
+		return "", ErrSessionValueType
+	}
+	return result, nil
+}
+
+func (self *httpSession) GetBool(key string) (bool, error) {
+	value, err := self.value(key)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, ErrSessionValueType
+	}
+	return result, nil
+}
+
+// 把值取到v中, v必须是指针. 类型不同时(例如没有注册的结构体解码后为map)通过JSON转换
+func (self *httpSession) GetInto(key string, v interface{}) error {
+	value, err := self.value(key)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrSessionValueType
+	}
+	target := rv.Elem()
+	if value != nil {
+		source := reflect.ValueOf(value)
+		if source.Type().AssignableTo(target.Type()) {
+			target.Set(source)
+			return nil
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ErrSessionValueType
+	}
+	if json.Unmarshal(encoded, v) != nil {
+		return ErrSessionValueType
+	}
+	return nil
+}
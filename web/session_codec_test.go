@@ -0,0 +1,102 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type codecUser struct {
+	Name string
+	Age  int
+}
+
+func TestSessionCodecs(t *testing.T) {
+	RegisterSessionType(codecUser{})
+	now := time.Now().Round(0)
+	for name, codec := range map[string]SessionCodec{
+		"json": JSONSessionCodec, "msgpack": MsgpackSessionCodec, "gob": GobSessionCodec,
+	} {
+		content, err := codec.Encode(map[string]interface{}{
+			"count": 3, "name": "dawn", "user": codecUser{"dawn", 3}, "time": now, "tags": []string{"a"},
+			"labels": map[string]string{"k": "v"},
+		})
+		if err != nil {
+			t.Error(name, "encode error:", err)
+			continue
+		}
+		data, err := codec.Decode(content)
+		if err != nil {
+			t.Error(name, "decode error:", err)
+			continue
+		}
+		if data["count"] != 3 || data["name"] != "dawn" || data["user"] != (codecUser{"dawn", 3}) {
+			t.Error(name, "type error:", data)
+		}
+		if value, ok := data["time"].(time.Time); !ok || !value.Equal(now) {
+			t.Error(name, "time error:", data["time"])
+		}
+		if tags, ok := data["tags"].([]string); !ok || tags[0] != "a" {
+			t.Error(name, "slice error:", data["tags"])
+		}
+		if labels, ok := data["labels"].(map[string]string); !ok || labels["k"] != "v" {
+			t.Error(name, "map error:", data["labels"])
+		}
+	}
+}
+
+func TestLegacyJSONSession(t *testing.T) {
+	// 旧版本直接保存的map
+	data, err := JSONSessionCodec.Decode([]byte(`{"count":3,"user":{"t":"x","v":1,"n":2},"name":"dawn"}`))
+	if err != nil || data["count"] != float64(3) || data["name"] != "dawn" {
+		t.Fatal("Legacy JSON decode error:", data, err)
+	}
+	if user, ok := data["user"].(map[string]interface{}); !ok || user["n"] != float64(2) {
+		t.Error("Legacy nested value error:", data["user"])
+	}
+	if data, err = JSONSessionCodec.Decode([]byte(`{}`)); err != nil || len(data) != 0 {
+		t.Error("Empty session decode error:", data, err)
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	session := sessionCtx.New()
+	session.Set("count", 1)
+	session.Set("ratio", 0.5)
+	session.Set("user", map[string]interface{}{"Name": "dawn", "Age": 3})
+	resp := httptest.NewRecorder()
+	sessionCtx.Save(resp, session)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(resp.Result().Cookies()[0])
+	session, _ = sessionCtx.Loads(req)
+
+	if count, err := session.GetInt("count"); count != 1 || err != nil {
+		t.Error("GetInt error:", count, err)
+	}
+	if _, err := session.GetInt("ratio"); err != ErrSessionValueType {
+		t.Error("GetInt should reject fraction:", err)
+	}
+	session.Set("big", uint64(math.MaxUint64))
+	session.Set("huge", 1e19)
+	if _, err := session.GetInt64("big"); err != ErrSessionValueType {
+		t.Error("GetInt64 should reject uint64 overflow:", err)
+	}
+	if _, err := session.GetInt64("huge"); err != ErrSessionValueType {
+		t.Error("GetInt64 should reject float overflow:", err)
+	}
+	if _, err := session.GetString("count"); err != ErrSessionValueType {
+		t.Error("GetString type error:", err)
+	}
+	if _, err := session.GetString("none"); err != ErrSessionKeyNotFound {
+		t.Error("Missing key error:", err)
+	}
+	var user codecUser
+	if err := session.GetInto("user", &user); err != nil || user.Name != "dawn" || user.Age != 3 {
+		t.Error("GetInto error:", user, err)
+	}
+}
@@ -0,0 +1,130 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	DEFAULT_SESSION_ID_BYTES = 32
+	MIN_SESSION_ID_BYTES     = 16
+	MIN_SESSION_ID_KEY_SIZE  = 16
+)
+
+var (
+	ErrInvalidSessionIDKey = errors.New("InvalidSessionIDKey")
+)
+
+// 生成新的session ID, 结果会直接作为driver的key和cookie的值使用
+type SessionIDGenerator interface {
+	NewID() string
+}
+
+// 使用crypto/rand生成随机字节, 编码为不带填充的URL安全base64
+type RandomIDGenerator struct {
+	size int
+}
+
+// size为随机字节数, 小于MIN_SESSION_ID_BYTES时使用MIN_SESSION_ID_BYTES
+func NewRandomIDGenerator(size int) *RandomIDGenerator {
+	if size < MIN_SESSION_ID_BYTES {
+		size = MIN_SESSION_ID_BYTES
+	}
+	return &RandomIDGenerator{size: size}
+}
+
+func (self *RandomIDGenerator) NewID() string {
+	buf := make([]byte, self.size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+var DEFAULT_SESSION_ID_GENERATOR SessionIDGenerator = NewRandomIDGenerator(DEFAULT_SESSION_ID_BYTES)
+
+// 签名后的值为"值.签名", 签名为HMAC-SHA256, 第一个key用于签名, 所有key都可以用于验证.
+// scope参与签名但不包含在结果中, 例如cookie的名字, 防止签名后的值被挪到别处使用
+type hmacSigner struct {
+	keys [][]byte
+}
+
+func newHMACSigner(keys [][]byte) (*hmacSigner, error) {
+	for _, key := range keys {
+		if len(key) < MIN_SESSION_ID_KEY_SIZE {
+			return nil, ErrInvalidSessionIDKey
+		}
+	}
+	return &hmacSigner{keys: keys}, nil
+}
+
+func (self *hmacSigner) mac(key []byte, scope string, value string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(scope))
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+func (self *hmacSigner) sign(scope string, value string) string {
+	return value + "." + base64.RawURLEncoding.EncodeToString(self.mac(self.keys[0], scope, value))
+}
+
+func (self *hmacSigner) verify(scope string, signed string) (string, bool) {
+	idx := strings.LastIndexByte(signed, '.')
+	if idx <= 0 {
+		return "", false
+	}
+	value := signed[:idx]
+	sig, err := base64.RawURLEncoding.DecodeString(signed[idx+1:])
+	if err != nil {
+		return "", false
+	}
+	for _, key := range self.keys {
+		if hmac.Equal(sig, self.mac(key, scope, value)) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// 设置新session ID的生成方式, 默认为DEFAULT_SESSION_ID_GENERATOR
+func (self *SessionContext) SetIDGenerator(generator SessionIDGenerator) {
+	self.idGenerator = generator
+}
+
+// 开启session ID签名, 签名错误的cookie在读取driver之前就会被拒绝(ErrInvalidSession).
+// 第一个key用于签名, 其余的只用于验证, 轮换key时把新key放在最前面; 不传key时关闭签名
+func (self *SessionContext) SetIDSigningKeys(keys ...[]byte) error {
+	if len(keys) == 0 {
+		self.idSigner = nil
+		return nil
+	}
+	signer, err := newHMACSigner(keys)
+	if err != nil {
+		return err
+	}
+	self.idSigner = signer
+	return nil
+}
+
+// session ID写入cookie时的值
+func (self *SessionContext) cookieValue(sid string) string {
+	if self.idSigner == nil {
+		return sid
+	}
+	return self.idSigner.sign("", sid)
+}
+
+// 从cookie的值中取出session ID, 签名错误时返回false
+func (self *SessionContext) parseCookieValue(value string) (string, bool) {
+	if self.idSigner == nil {
+		return value, true
+	}
+	return self.idSigner.verify("", value)
+}
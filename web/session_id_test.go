@@ -0,0 +1,74 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomIDGenerator(t *testing.T) {
+	id := NewRandomIDGenerator(24).NewID()
+	if strings.ContainsAny(id, "=+/") {
+		t.Error("ID should be URL safe without padding:", id)
+	}
+	if raw, err := base64.RawURLEncoding.DecodeString(id); err != nil || len(raw) != 24 {
+		t.Error("ID length error:", id, err)
+	}
+	if len(NewRandomIDGenerator(1).NewID()) != base64.RawURLEncoding.EncodedLen(MIN_SESSION_ID_BYTES) {
+		t.Error("Short ID should use minimum size")
+	}
+	if DEFAULT_SESSION_ID_GENERATOR.NewID() == DEFAULT_SESSION_ID_GENERATOR.NewID() {
+		t.Error("ID should be random")
+	}
+}
+
+func TestSignedSessionID(t *testing.T) {
+	driver := &brokenDriver{MemoryDriver: NewMemorySessionDriver(MemoryGCInterval(0))}
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	if sessionCtx.SetIDSigningKeys([]byte("short")) != ErrInvalidSessionIDKey {
+		t.Error("Short key should be rejected")
+	}
+	oldKey, newKey := bytes.Repeat([]byte("o"), 32), bytes.Repeat([]byte("n"), 32)
+	sessionCtx.SetIDSigningKeys(oldKey)
+
+	session := sessionCtx.New()
+	session.Set("user", "dawn")
+	resp := httptest.NewRecorder()
+	sessionCtx.Save(resp, session)
+	cookie := sessionCookie(resp)
+	sid, _ := session.ID()
+	if !strings.HasPrefix(cookie.Value, sid+".") {
+		t.Fatal("Cookie should contain signed ID:", cookie.Value)
+	}
+	if data, _ := driver.Get(sid); data == nil {
+		t.Error("Driver key should be the unsigned ID")
+	}
+
+	request := func(value string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "sid", Value: value})
+		return req
+	}
+	sessionCtx.SetIDSigningKeys(newKey, oldKey)
+	if loaded, err := sessionCtx.Loads(request(cookie.Value)); err != nil || loaded == nil {
+		t.Error("Old key should still verify:", err)
+	}
+
+	// driver不可用时伪造的cookie仍然返回ErrInvalidSession, 说明没有读取driver
+	driver.broken = true
+	for _, forged := range []string{sid, sid + ".AAAA", tamper(cookie.Value), "." + cookie.Value} {
+		if _, err := sessionCtx.Loads(request(forged)); err != ErrInvalidSession {
+			t.Error("Forged cookie should be rejected:", forged, err)
+		}
+	}
+	sessionCtx.SetIDSigningKeys(newKey)
+	if _, err := sessionCtx.Loads(request(cookie.Value)); err != ErrInvalidSession {
+		t.Error("Removed key should not verify:", err)
+	}
+}
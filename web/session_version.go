@@ -0,0 +1,96 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionConflict    = errors.New("SessionConflict")
+	ErrDriverNotVersioned = errors.New("SessionDriverNotVersioned")
+)
+
+var (
+	MAX_SESSION_MERGE_RETRY = 3
+)
+
+// 支持乐观并发控制的driver, 每次写入后版本号加1, key不存在时版本号为0
+type VersionedSessionDriver interface {
+	SessionDriver
+	GetVersion(string) ([]byte, int64, error)
+	// 只有当前版本号等于version时才写入, 返回新的版本号, 版本号不同时返回ErrSessionConflict
+	SetIfVersion(string, []byte, int64, time.Duration) (int64, error)
+}
+
+// 保存时发现session已经被其他请求修改, stored为driver中最新的数据, local为当前请求中的数据,
+// changed为当前请求修改或删除过的key, 返回合并后要保存的数据
+type MergeFunc func(stored map[string]interface{}, local map[string]interface{}, changed []string) (map[string]interface{}, error)
+
+// 把当前请求修改过的key合并到最新的数据上, 其他key保持driver中的值
+func MergeChangedKeys(stored map[string]interface{}, local map[string]interface{}, changed []string) (map[string]interface{}, error) {
+	for _, key := range changed {
+		if value, ok := local[key]; ok {
+			stored[key] = value
+		} else {
+			delete(stored, key)
+		}
+	}
+	return stored, nil
+}
+
+// 开启乐观并发控制, driver必须实现VersionedSessionDriver. 同一个session被并发修改时,
+// merge为nil则保存返回ErrSessionConflict, 否则使用merge合并后重试
+func (self *SessionContext) EnableVersioning(merge MergeFunc) error {
+	if _, ok := self.driver.(VersionedSessionDriver); !ok {
+		return ErrDriverNotVersioned
+	}
+	self.versioned = true
+	self.merge = merge
+	return nil
+}
+
+func (self *SessionContext) setVersioned(session Session, sid string, data map[string]interface{}) error {
+	driver := self.driver.(VersionedSessionDriver)
+	s, ok := session.(*httpSession)
+	if !ok {
+		return ErrDriverNotVersioned
+	}
+	for retry := 0; ; retry++ {
+		encodeData, err := self.codec.Encode(data)
+		if err != nil {
+			return err
+		}
+		version, err := driver.SetIfVersion(sid, encodeData, s.version, self.sessionAge)
+		if err == nil {
+			s.version = version
+			s.data = data
+			return nil
+		}
+		if err != ErrSessionConflict || self.merge == nil || retry >= MAX_SESSION_MERGE_RETRY {
+			return err
+		}
+
+		content, version, err := driver.GetVersion(sid)
+		if err != nil {
+			return err
+		}
+		stored := make(map[string]interface{})
+		if content != nil {
+			stored, err = self.codec.Decode(content)
+			if err != nil {
+				return err
+			}
+		}
+		changed := make([]string, 0, len(s.changed))
+		for key := range s.changed {
+			changed = append(changed, key)
+		}
+		data, err = self.merge(stored, data, changed)
+		if err != nil {
+			return err
+		}
+		s.version = version
+	}
+}
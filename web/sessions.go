@@ -5,31 +5,80 @@ package web
 import (
 	"errors"
 	"github.com/pungle/dawn/logging"
-	"github.com/pungle/dawn/uuid"
 	"net/http"
 	"time"
 )
 
+// 保存编码后的session数据, key不存在时Get返回nil, Delete和Touch不存在的key不是错误
 type SessionDriver interface {
-	Get(string) (interface{}, error)
-	Set(string, interface{}, time.Duration) error
+	Get(string) ([]byte, error)
+	Set(string, []byte, time.Duration) error
+	Delete(string) error
+	Touch(string, time.Duration) error
 }
 
 var (
 	EmptySessionErr   = errors.New("session is empty")
 	SessionNotInitErr = errors.New("session not init")
+
+	// Loads的错误: 没有session的cookie, session已过期或不存在, session数据无法解析, driver读取失败
+	ErrNoSession         = errors.New("NoSession")
+	ErrSessionExpired    = errors.New("SessionExpired")
+	ErrInvalidSession    = errors.New("InvalidSession")
+	ErrDriverUnavailable = errors.New("DriverUnavailable")
+)
+
+// driver读取失败时的处理方式
+type SessionFailurePolicy int
+
+const (
+	// ctx.Session()返回ErrDriverUnavailable, 请求继续处理, Flash等方法可以创建新的session
+	SESSION_FAIL_OPEN SessionFailurePolicy = iota
+	// ctx.Session()返回ErrDriverUnavailable的同时响应503, 不会创建新的session覆盖用户原来的cookie
+	SESSION_FAIL_CLOSED
 )
 
 type Session interface {
 	Get(string) (interface{}, error)
 	Set(string, interface{}) error
+	Delete(string) error
 	Values() (map[string]interface{}, error)
 	ID() (string, error)
+
+	GetInt(string) (int, error)
+	GetInt64(string) (int64, error)
+	GetFloat64(string) (float64, error)
+	GetString(string) (string, error)
+	GetBool(string) (bool, error)
+	GetInto(string, interface{}) error
 }
 
 type httpSession struct {
 	sid  string
 	data map[string]interface{}
+
+	// 使用CookieStore时读取到的拆分cookie数量
+	chunks int
+	// Set之后还没有保存, 直接修改Values返回的map不会被记录
+	dirty   bool
+	changed map[string]bool
+	// 开启版本控制时读取到的版本号, 新的session为0
+	version int64
+	// 客户端已经持有这个session的cookie(从请求中读取或已经保存过)
+	delivered bool
+}
+
+func (self *httpSession) change(key string) {
+	self.dirty = true
+	if self.changed == nil {
+		self.changed = make(map[string]bool)
+	}
+	self.changed[key] = true
+}
+
+func (self *httpSession) saved() {
+	self.dirty = false
+	self.changed = nil
 }
 
 func (self *httpSession) Get(key string) (interface{}, error) {
@@ -44,6 +93,18 @@ func (self *httpSession) Set(key string, value interface{}) error {
 		return SessionNotInitErr
 	}
 	self.data[key] = value
+	self.change(key)
+	return nil
+}
+
+func (self *httpSession) Delete(key string) error {
+	if self.data == nil {
+		return SessionNotInitErr
+	}
+	if _, ok := self.data[key]; ok {
+		delete(self.data, key)
+		self.change(key)
+	}
 	return nil
 }
 
@@ -64,14 +125,30 @@ func (self *httpSession) ID() (string, error) {
 type SessionContext struct {
 	driver SessionDriver
 
-	cookieName     string
-	cookiePath     string
-	cookieDomain   string
-	cookieExpire   time.Duration
-	cookieHttpOnly bool
-	cookieSecure   bool
+	cookieName        string
+	cookiePath        string
+	cookieDomain      string
+	cookieExpire      time.Duration
+	cookieHttpOnly    bool
+	cookieSecure      bool
+	cookieSameSite    http.SameSite
+	cookiePartitioned bool
 
 	sessionAge time.Duration
+
+	cookieStore *CookieStore
+	codec       SessionCodec
+
+	autoSave bool
+	sliding  bool
+
+	versioned bool
+	merge     MergeFunc
+
+	failurePolicy SessionFailurePolicy
+
+	idGenerator SessionIDGenerator
+	idSigner    *hmacSigner
 }
 
 func NewSessionContext(driver SessionDriver, cookieName string,
@@ -80,48 +157,135 @@ func NewSessionContext(driver SessionDriver, cookieName string,
 	cookieSecure bool, sessionAge time.Duration) *SessionContext {
 
 	return &SessionContext{
-		driver,
-		cookieName,
-		cookiePath,
-		cookieDomain,
-		cookieExpire,
-		cookieHttpOnly,
-		cookieSecure,
-		sessionAge,
+		driver:         driver,
+		cookieName:     cookieName,
+		cookiePath:     cookiePath,
+		cookieDomain:   cookieDomain,
+		cookieExpire:   cookieExpire,
+		cookieHttpOnly: cookieHttpOnly,
+		cookieSecure:   cookieSecure,
+		cookieSameSite: http.SameSiteLaxMode,
+		sessionAge:     sessionAge,
+		codec:          DEFAULT_SESSION_CODEC,
+		autoSave:       true,
+		idGenerator:    DEFAULT_SESSION_ID_GENERATOR,
 	}
 }
 
+// handler中修改过的session会在写入响应头之前自动保存, 默认开启
+func (self *SessionContext) SetAutoSave(autoSave bool) {
+	self.autoSave = autoSave
+}
+
+// 开启后没有修改的session也会在每次请求时延长有效期, 需要同时开启自动保存
+func (self *SessionContext) SetSlidingExpiration(sliding bool) {
+	self.sliding = sliding
+}
+
+// 设置session cookie的SameSite属性, 默认为http.SameSiteLaxMode, SameSiteNoneMode需要cookie为secure
+func (self *SessionContext) SetSameSite(mode http.SameSite) {
+	self.cookieSameSite = mode
+}
+
+// 设置session cookie的Partitioned属性(CHIPS), 需要cookie为secure
+func (self *SessionContext) SetPartitioned(partitioned bool) {
+	self.cookiePartitioned = partitioned
+}
+
+// 检查cookie的配置是否会被浏览器拒绝, 包括SameSite=None, Partitioned和__Secure-/__Host-前缀的要求.
+// 配置错误时Save会返回同样的错误
+func (self *SessionContext) Validate() error {
+	return checkCookie(self.newCookie(self.cookieName, ""))
+}
+
+// 设置driver读取失败时的处理方式, 默认为SESSION_FAIL_OPEN
+func (self *SessionContext) SetFailurePolicy(policy SessionFailurePolicy) {
+	self.failurePolicy = policy
+}
+
+// 读取session失败时是否可以创建新的session替换
+func (self *SessionContext) replaceable(err error) bool {
+	return err != ErrDriverUnavailable || self.failurePolicy == SESSION_FAIL_OPEN
+}
+
+// 设置session数据的编码方式, 默认为JSONSessionCodec
+func (self *SessionContext) SetCodec(codec SessionCodec) {
+	self.codec = codec
+}
+
 func (self *SessionContext) New() Session {
 	session := &httpSession{
-		uuid.NewUUID().Base64(),
-		make(map[string]interface{}),
+		sid:  self.idGenerator.NewID(),
+		data: make(map[string]interface{}),
 	}
 	return session
 }
 
-func (self *SessionContext) Loads(req *http.Request) Session {
+// 读取请求中的session, 没有可用的session时返回nil和ErrNoSession, ErrSessionExpired,
+// ErrInvalidSession或ErrDriverUnavailable
+func (self *SessionContext) Loads(req *http.Request) (Session, error) {
+	if self.cookieStore != nil {
+		return self.loadCookie(req)
+	}
 	cookie, _ := req.Cookie(self.cookieName)
+	if cookie == nil || cookie.Value == "" {
+		return nil, ErrNoSession
+	}
+	sid, ok := self.parseCookieValue(cookie.Value)
+	if !ok {
+		return nil, ErrInvalidSession
+	}
 
-	if cookie != nil {
-		data, err := self.driver.Get(cookie.Value)
-		if err != nil {
-			logging.Error("LoadSession error: %s, key: %s", err.Error(), cookie.Value)
-			return nil
-		}
-		if data == nil {
-			return nil
-		}
-		sessionData := data.(map[string]interface{})
-		session := &httpSession{
-			cookie.Value,
-			sessionData,
+	var data []byte
+	var version int64
+	var err error
+	if self.versioned {
+		data, version, err = self.driver.(VersionedSessionDriver).GetVersion(sid)
+	} else {
+		data, err = self.driver.Get(sid)
+	}
+	if err != nil {
+		logging.Error("LoadSession error: %s, key: %s", err.Error(), sid)
+		if err == ErrBadSessionFile {
+			// 文件损坏不是driver不可用, 可以创建新的session
+			return nil, ErrInvalidSession
 		}
-		return session
+		return nil, ErrDriverUnavailable
 	}
-	return nil
+	if data == nil {
+		return nil, ErrSessionExpired
+	}
+	sessionData, err := self.decode(data)
+	if err != nil {
+		logging.Error("LoadSession error: %s, key: %s", err.Error(), sid)
+		return nil, ErrInvalidSession
+	}
+	session := &httpSession{
+		sid:       sid,
+		data:      sessionData,
+		version:   version,
+		delivered: true,
+	}
+	return session, nil
+}
+
+// 损坏的数据可能解码出nil, 当作无效的session
+func (self *SessionContext) decode(content []byte) (map[string]interface{}, error) {
+	data, err := self.codec.Decode(content)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrInvalidSession
+	}
+	return data, nil
 }
 
 func (self *SessionContext) Save(resp http.ResponseWriter, session Session) error {
+	if err := self.Validate(); err != nil {
+		logging.Error("SaveSession error: %s, cookie: %s", err.Error(), self.cookieName)
+		return err
+	}
 	data, err := session.Values()
 	if err != nil {
 		return err
@@ -136,23 +300,159 @@ func (self *SessionContext) Save(resp http.ResponseWriter, session Session) erro
 		return err
 	}
 
-	err = self.driver.Set(sid, data, self.sessionAge)
+	if self.cookieStore != nil {
+		var encodeData []byte
+		encodeData, err = self.codec.Encode(data)
+		if err != nil {
+			return err
+		}
+		var chunks int
+		if s, ok := session.(*httpSession); ok {
+			chunks = s.chunks
+		}
+		err = self.saveCookie(resp, sid, encodeData, chunks)
+	} else {
+		err = self.setData(session, sid, data)
+		if err == nil {
+			http.SetCookie(resp, self.newCookie(self.cookieName, self.cookieValue(sid)))
+		}
+	}
+
+	if err != nil {
+		logging.Error("SaveSession error: %s, sid: %s", err.Error(), sid)
+		return err
+	}
+	if s, ok := session.(*httpSession); ok {
+		s.saved()
+		s.delivered = true
+	}
+	return nil
+}
+
+// 把session数据写入driver, 开启版本控制时使用SetIfVersion
+func (self *SessionContext) setData(session Session, sid string, data map[string]interface{}) error {
+	if self.versioned {
+		return self.setVersioned(session, sid, data)
+	}
+	encodeData, err := self.codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	return self.driver.Set(sid, encodeData, self.sessionAge)
+}
+
+// 延长session和cookie的有效期, 使用CookieStore时会重新生成cookie
+func (self *SessionContext) Touch(resp http.ResponseWriter, session Session) error {
+	if self.cookieStore != nil {
+		return self.Save(resp, session)
+	}
+	if err := self.Validate(); err != nil {
+		return err
+	}
+	sid, err := session.ID()
+	if err != nil {
+		return err
+	}
+	if err := self.driver.Touch(sid, self.sessionAge); err != nil {
+		logging.Error("TouchSession error: %s, sid: %s", err.Error(), sid)
+		return err
+	}
+	http.SetCookie(resp, self.newCookie(self.cookieName, self.cookieValue(sid)))
+	return nil
+}
 
+// 响应头已经发出时只能更新driver中的数据
+func (self *SessionContext) store(session Session) error {
+	if self.cookieStore != nil {
+		logging.Warn("Session modified after response header written, changes are lost.")
+		return nil
+	}
+	data, err := session.Values()
 	if err != nil {
+		return err
+	}
+	sid, err := session.ID()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return self.driver.Delete(sid)
+	}
+	if err := self.setData(session, sid, data); err != nil {
 		logging.Error("SaveSession error: %s, sid: %s", err.Error(), sid)
 		return err
 	}
-	cookieTime := time.Now().Add(self.cookieExpire)
-	cookie := &http.Cookie{
-		Name:     self.cookieName,
-		Value:    sid,
-		Path:     self.cookiePath,
-		Domain:   self.cookieDomain,
-		Expires:  cookieTime,
-		MaxAge:   int(self.cookieExpire / time.Second),
-		HttpOnly: self.cookieHttpOnly,
-		Secure:   self.cookieSecure,
+	if s, ok := session.(*httpSession); ok {
+		s.saved()
 	}
-	http.SetCookie(resp, cookie)
 	return nil
 }
+
+// 从driver中删除session并让浏览器删除cookie. 使用CookieStore时已经发出的cookie在过期前仍然有效
+func (self *SessionContext) Destroy(resp http.ResponseWriter, session Session) error {
+	var chunks int
+	if session != nil {
+		if s, ok := session.(*httpSession); ok {
+			chunks = s.chunks
+		}
+		sid, err := session.ID()
+		if err == nil && self.driver != nil {
+			if err := self.driver.Delete(sid); err != nil {
+				logging.Error("DestroySession error: %s, sid: %s", err.Error(), sid)
+				return err
+			}
+		}
+	}
+	self.expireCookie(resp, self.cookieName)
+	for idx := 0; idx < chunks; idx++ {
+		self.expireCookie(resp, chunkName(self.cookieName, idx))
+	}
+	return nil
+}
+
+// 使用新的session ID保存session中的数据并删除旧的session, 登录等权限变化后调用以防止会话固定攻击
+func (self *SessionContext) Regenerate(resp http.ResponseWriter, session Session) (Session, error) {
+	data, err := session.Values()
+	if err != nil {
+		return nil, err
+	}
+	oldSid, _ := session.ID()
+	result := self.New().(*httpSession)
+	for key, value := range data {
+		result.data[key] = value
+	}
+	if s, ok := session.(*httpSession); ok {
+		result.chunks = s.chunks
+	}
+	if err := self.Save(resp, result); err != nil {
+		return nil, err
+	}
+	if self.driver != nil && oldSid != "" {
+		if err := self.driver.Delete(oldSid); err != nil {
+			logging.Error("RegenerateSession error: %s, sid: %s", err.Error(), oldSid)
+		}
+	}
+	return result, nil
+}
+
+func (self *SessionContext) newCookie(name string, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:        name,
+		Value:       value,
+		Path:        self.cookiePath,
+		Domain:      self.cookieDomain,
+		Expires:     time.Now().Add(self.cookieExpire),
+		MaxAge:      int(self.cookieExpire / time.Second),
+		HttpOnly:    self.cookieHttpOnly,
+		Secure:      self.cookieSecure,
+		SameSite:    self.cookieSameSite,
+		Partitioned: self.cookiePartitioned,
+	}
+}
+
+func (self *SessionContext) expireCookie(resp http.ResponseWriter, name string) {
+	cookie := self.newCookie(name, "")
+	cookie.Expires = time.Unix(0, 0)
+	cookie.MaxAge = -1
+	http.SetCookie(resp, cookie)
+}
@@ -0,0 +1,319 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newSessionServer(driver SessionDriver) *HttpServer {
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	return NewServer(NewHttpConfig(":0"), sessionCtx, &discardHandler{})
+}
+
+func sessionCookie(resp *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == "sid" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestDestroyAndRegenerateSession(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	server := newSessionServer(driver)
+	server.AddHandler("/login", func(ctx *HttpContext) {
+		session := ctx.NewSession()
+		session.Set("user", "guest")
+		ctx.SaveSession()
+	})
+	server.AddHandler("/regenerate", func(ctx *HttpContext) {
+		session, err := ctx.RegenerateSession()
+		if err != nil {
+			t.Error("Regenerate error:", err)
+		}
+		if user, _ := session.GetString("user"); user != "guest" {
+			t.Error("Regenerated session should keep data:", user)
+		}
+	})
+	server.AddHandler("/logout", func(ctx *HttpContext) {
+		ctx.DestroySession()
+		if session, err := ctx.Session(); session != nil || err != ErrNoSession {
+			t.Error("Session should be nil after destroy:", err)
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/login", nil))
+	old := sessionCookie(resp)
+
+	req := httptest.NewRequest("GET", "/regenerate", nil)
+	req.AddCookie(old)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	cookie := sessionCookie(resp)
+	if cookie == nil || cookie.Value == old.Value {
+		t.Fatal("Session id should be changed")
+	}
+	if data, _ := driver.Get(old.Value); data != nil {
+		t.Error("Old session should be deleted")
+	}
+
+	req = httptest.NewRequest("GET", "/logout", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if expired := sessionCookie(resp); expired == nil || expired.MaxAge >= 0 {
+		t.Error("Cookie should be expired:", expired)
+	}
+	if data, _ := driver.Get(cookie.Value); data != nil {
+		t.Error("Destroyed session should be deleted")
+	}
+}
+
+type countingDriver struct {
+	*MemoryDriver
+	sets    int
+	touches int
+}
+
+func (self *countingDriver) Set(key string, value []byte, expire time.Duration) error {
+	self.sets++
+	return self.MemoryDriver.Set(key, value, expire)
+}
+
+func (self *countingDriver) Touch(key string, expire time.Duration) error {
+	self.touches++
+	return self.MemoryDriver.Touch(key, expire)
+}
+
+func TestAutoSaveSession(t *testing.T) {
+	driver := &countingDriver{MemoryDriver: NewMemorySessionDriver(MemoryGCInterval(0))}
+	server := newSessionServer(driver)
+	server.AddHandler("/set", func(ctx *HttpContext) {
+		ctx.NewSession().Set("count", 1)
+		ctx.Response.Write([]byte("ok"))
+		// 响应头发出后的修改只保存到driver中
+		session, _ := ctx.Session()
+		session.Set("count", 2)
+	})
+	server.AddHandler("/get", func(ctx *HttpContext) {
+		session, _ := ctx.Session()
+		if count, _ := session.GetInt("count"); count != 2 {
+			t.Error("Auto saved value error:", count)
+		}
+	})
+	server.AddHandler("/timeout", func(ctx *HttpContext) {
+		session, _ := ctx.Session()
+		session.Set("count", 3)
+	}, RouteTimeout(time.Second))
+	server.AddHandler("/late", func(ctx *HttpContext) {
+		ctx.Response.Write([]byte("ok"))
+		// cookie已经无法发出, 不应该在driver中留下session
+		ctx.NewSession().Set("count", 1)
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/late", nil))
+	if driver.sets != 0 || driver.Len() != 0 || sessionCookie(resp) != nil {
+		t.Error("Session created after header should not be stored:", driver.sets, driver.Len())
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/set", nil))
+	cookie := sessionCookie(resp)
+	if cookie == nil || driver.sets != 2 {
+		t.Fatal("Session should be saved automatically:", cookie, driver.sets)
+	}
+
+	req := httptest.NewRequest("GET", "/get", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if driver.sets != 2 || sessionCookie(resp) != nil {
+		t.Error("Unchanged session should not be saved:", driver.sets)
+	}
+
+	server.sessionCtx.SetSlidingExpiration(true)
+	req = httptest.NewRequest("GET", "/get", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if driver.touches != 1 || sessionCookie(resp) == nil {
+		t.Error("Sliding expiration should touch session:", driver.touches)
+	}
+
+	req = httptest.NewRequest("GET", "/timeout", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if driver.sets != 3 || sessionCookie(resp) == nil {
+		t.Error("Session should be saved through timeout writer:", driver.sets)
+	}
+}
+
+func TestSessionAfterTimeout(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	server := newSessionServer(driver)
+	done := make(chan struct{})
+	server.AddHandler("/slow", func(ctx *HttpContext) {
+		defer time.AfterFunc(20*time.Millisecond, func() { close(done) })
+		ctx.NewSession().Set("count", 1)
+		time.Sleep(50 * time.Millisecond)
+	}, RouteTimeout(10*time.Millisecond))
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/slow", nil))
+	<-done
+	if resp.Code != http.StatusServiceUnavailable || sessionCookie(resp) != nil || driver.Len() != 0 {
+		t.Error("Timed out request should not save session:", resp.Code, driver.Len())
+	}
+}
+
+func TestSessionVersioning(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	if err := sessionCtx.EnableVersioning(nil); err != nil {
+		t.Fatal(err)
+	}
+	session := sessionCtx.New()
+	session.Set("a", 0)
+	resp := httptest.NewRecorder()
+	sessionCtx.Save(resp, session)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(sessionCookie(resp))
+
+	first, _ := sessionCtx.Loads(req)
+	second, _ := sessionCtx.Loads(req)
+	first.Set("a", 1)
+	second.Set("b", 2)
+	if err := sessionCtx.Save(httptest.NewRecorder(), first); err != nil {
+		t.Error("First save error:", err)
+	}
+	if err := sessionCtx.Save(httptest.NewRecorder(), second); err != ErrSessionConflict {
+		t.Error("Concurrent save should conflict:", err)
+	}
+
+	sessionCtx.EnableVersioning(MergeChangedKeys)
+	if err := sessionCtx.Save(httptest.NewRecorder(), second); err != nil {
+		t.Error("Merge save error:", err)
+	}
+	merged, _ := sessionCtx.Loads(req)
+	a, _ := merged.GetInt("a")
+	b, _ := merged.GetInt("b")
+	if a != 1 || b != 2 {
+		t.Error("Merge result error:", a, b)
+	}
+
+	fileDriver, _ := NewFileSessionDriver(t.TempDir(), FileGCInterval(0))
+	sessionCtx = NewSessionContext(fileDriver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	if sessionCtx.EnableVersioning(nil) != ErrDriverNotVersioned {
+		t.Error("File driver does not support versioning")
+	}
+}
+
+func TestAutoSaveConflict(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	server := newSessionServer(driver)
+	server.sessionCtx.EnableVersioning(nil)
+	var before, after error
+	server.AddHandler("/login", func(ctx *HttpContext) {
+		ctx.NewSession().Set("a", 0)
+	})
+	server.AddHandler("/update", func(ctx *HttpContext) {
+		session, _ := ctx.Session()
+		// 模拟同时处理的另一个请求先保存了session
+		other, _ := server.sessionCtx.Loads(ctx.Request)
+		other.Set("a", 1)
+		server.sessionCtx.Save(httptest.NewRecorder(), other)
+
+		session.Set("b", 2)
+		before = ctx.SessionError()
+		ctx.Response.Write([]byte("ok"))
+		after = ctx.SessionError()
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/login", nil))
+	req := httptest.NewRequest("GET", "/update", nil)
+	req.AddCookie(sessionCookie(resp))
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	if before != nil || after != ErrSessionConflict {
+		t.Error("Auto save conflict should be reported:", before, after)
+	}
+}
+
+type brokenDriver struct {
+	*MemoryDriver
+	broken bool
+}
+
+func (self *brokenDriver) Get(key string) ([]byte, error) {
+	if self.broken {
+		return nil, ErrRedisNotConnect
+	}
+	return self.MemoryDriver.Get(key)
+}
+
+func TestSessionLoadErrors(t *testing.T) {
+	driver := &brokenDriver{MemoryDriver: NewMemorySessionDriver(MemoryGCInterval(0))}
+	sessionCtx := NewSessionContext(driver, "sid", "", time.Hour, "/", true, false, time.Hour)
+	request := func(sid string) *http.Request {
+		req := httptest.NewRequest("GET", "/flash", nil)
+		if sid != "" {
+			req.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+		}
+		return req
+	}
+
+	if _, err := sessionCtx.Loads(request("")); err != ErrNoSession {
+		t.Error("Missing cookie error:", err)
+	}
+	if _, err := sessionCtx.Loads(request("unknown")); err != ErrSessionExpired {
+		t.Error("Unknown session error:", err)
+	}
+	driver.Set("corrupt", []byte("{bad json"), time.Hour)
+	if _, err := sessionCtx.Loads(request("corrupt")); err != ErrInvalidSession {
+		t.Error("Corrupt session error:", err)
+	}
+	sessionCtx.SetCodec(GobSessionCodec)
+	if _, err := sessionCtx.Loads(request("corrupt")); err != ErrInvalidSession {
+		t.Error("Corrupt gob session error:", err)
+	}
+	sessionCtx.SetCodec(JSONSessionCodec)
+	driver.Set("sid", []byte(`{"user":{"t":"string","v":"dawn"}}`), time.Hour)
+	driver.broken = true
+	if session, err := sessionCtx.Loads(request("sid")); session != nil || err != ErrDriverUnavailable {
+		t.Error("Driver error:", session, err)
+	}
+
+	// 默认fail-open, Flash会创建新的session
+	server := NewServer(NewHttpConfig(":0"), sessionCtx, &discardHandler{})
+	server.AddHandler("/flash", func(ctx *HttpContext) {
+		if err := ctx.Flash("notice", "hi"); err != nil {
+			t.Error("Fail-open flash error:", err)
+		}
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, request("sid"))
+	if resp.Code != http.StatusOK || sessionCookie(resp) == nil {
+		t.Error("Fail-open should create new session:", resp.Code)
+	}
+
+	sessionCtx.SetFailurePolicy(SESSION_FAIL_CLOSED)
+	server = NewServer(NewHttpConfig(":0"), sessionCtx, &discardHandler{})
+	server.AddHandler("/flash", func(ctx *HttpContext) {
+		if err := ctx.Flash("notice", "hi"); err != ErrDriverUnavailable {
+			t.Error("Fail-closed flash error:", err)
+		}
+	})
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, request("sid"))
+	if resp.Code != http.StatusServiceUnavailable || sessionCookie(resp) != nil {
+		t.Error("Fail-closed should respond 503 without cookie:", resp.Code)
+	}
+}
@@ -0,0 +1,287 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	DEFAULT_SQL_TABLE            = "sessions"
+	DEFAULT_SQL_CLEANUP_INTERVAL = 10 * time.Minute
+)
+
+var (
+	ErrInvalidTableName  = errors.New("InvalidTableName")
+	ErrInvalidSQLDialect = errors.New("InvalidSQLDialect")
+)
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// 不同数据库的占位符, 二进制类型和upsert语法
+type SQLDialect struct {
+	Name        string
+	Placeholder func(n int) string
+	BlobType    string
+	// 参数依次为表名和三个占位符(id, data, expires)
+	UpsertFormat string
+}
+
+var (
+	PostgresDialect = &SQLDialect{
+		Name:         "postgres",
+		Placeholder:  func(n int) string { return "$" + strconv.Itoa(n) },
+		BlobType:     "BYTEA",
+		UpsertFormat: "INSERT INTO %s (id, data, expires) VALUES (%s, %s, %s) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expires = EXCLUDED.expires",
+	}
+	MySQLDialect = &SQLDialect{
+		Name:         "mysql",
+		Placeholder:  func(n int) string { return "?" },
+		BlobType:     "MEDIUMBLOB",
+		UpsertFormat: "INSERT INTO %s (id, data, expires) VALUES (%s, %s, %s) ON DUPLICATE KEY UPDATE data = VALUES(data), expires = VALUES(expires)",
+	}
+	SQLiteDialect = &SQLDialect{
+		Name:         "sqlite",
+		Placeholder:  func(n int) string { return "?" },
+		BlobType:     "BLOB",
+		UpsertFormat: "INSERT INTO %s (id, data, expires) VALUES (%s, %s, %s) ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires = excluded.expires",
+	}
+)
+
+type SQLDriverOption func(*SQLDriver)
+
+// 清理过期session的间隔, interval<=0表示不启动清理的goroutine
+func SQLCleanupInterval(interval time.Duration) SQLDriverOption {
+	return func(d *SQLDriver) {
+		d.cleanupInterval = interval
+	}
+}
+
+func SQLTable(table string) SQLDriverOption {
+	return func(d *SQLDriver) {
+		d.table = table
+	}
+}
+
+// 使用database/sql保存session, 表结构见Migrate, expires为unix秒, 0表示不过期.
+// 实现了VersionedSessionDriver, version为0的行(例如由Set写入)在第一次SetIfVersion时从1开始
+type SQLDriver struct {
+	db              *sql.DB
+	dialect         *SQLDialect
+	table           string
+	cleanupInterval time.Duration
+
+	getSQL     string
+	upsertSQL  string
+	deleteSQL  string
+	touchSQL   string
+	cleanupSQL string
+
+	getVersionSQL    string
+	updateVersionSQL string
+	insertVersionSQL string
+	deleteExpiredSQL string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewSQLDriver(db *sql.DB, dialect *SQLDialect, opts ...SQLDriverOption) (*SQLDriver, error) {
+	if dialect == nil || dialect.Placeholder == nil {
+		return nil, ErrInvalidSQLDialect
+	}
+	driver := &SQLDriver{
+		db:              db,
+		dialect:         dialect,
+		table:           DEFAULT_SQL_TABLE,
+		cleanupInterval: DEFAULT_SQL_CLEANUP_INTERVAL,
+		stop:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(driver)
+	}
+	if !tableNamePattern.MatchString(driver.table) {
+		return nil, ErrInvalidTableName
+	}
+	p := dialect.Placeholder
+	table := driver.table
+	driver.getSQL = fmt.Sprintf("SELECT data, expires FROM %s WHERE id = %s", table, p(1))
+	driver.upsertSQL = fmt.Sprintf(dialect.UpsertFormat, table, p(1), p(2), p(3))
+	driver.deleteSQL = fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, p(1))
+	driver.touchSQL = fmt.Sprintf("UPDATE %s SET expires = %s WHERE id = %s", table, p(1), p(2))
+	driver.cleanupSQL = fmt.Sprintf("DELETE FROM %s WHERE expires > 0 AND expires < %s", table, p(1))
+	driver.getVersionSQL = fmt.Sprintf("SELECT data, expires, version FROM %s WHERE id = %s", table, p(1))
+	driver.updateVersionSQL = fmt.Sprintf(
+		"UPDATE %s SET data = %s, expires = %s, version = %s WHERE id = %s AND version = %s AND (expires = 0 OR expires >= %s)",
+		table, p(1), p(2), p(3), p(4), p(5), p(6))
+	driver.insertVersionSQL = fmt.Sprintf("INSERT INTO %s (id, data, expires, version) VALUES (%s, %s, %s, 1)", table, p(1), p(2), p(3))
+	driver.deleteExpiredSQL = fmt.Sprintf("DELETE FROM %s WHERE id = %s AND expires > 0 AND expires < %s", table, p(1), p(2))
+	if driver.cleanupInterval > 0 {
+		go driver.cleaner()
+	}
+	return driver, nil
+}
+
+// 创建session表和过期时间的索引, 表已存在时不做任何修改
+func (self *SQLDriver) Migrate() error {
+	_, err := self.db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (id VARCHAR(128) NOT NULL PRIMARY KEY, data %s NOT NULL, expires BIGINT NOT NULL, version BIGINT NOT NULL DEFAULT 0)",
+		self.table, self.dialect.BlobType))
+	if err != nil {
+		return err
+	}
+	index := indexName(self.table) + "_expires"
+	if self.dialect == MySQLDialect {
+		// MySQL的CREATE INDEX不支持IF NOT EXISTS, 先检查索引是否存在
+		exists, err := self.mysqlIndexExists(index)
+		if err != nil || exists {
+			return err
+		}
+		_, err = self.db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (expires)", index, self.table))
+		return err
+	}
+	_, err = self.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (expires)", index, self.table))
+	return err
+}
+
+// 没有指定schema时使用当前连接的数据库
+func (self *SQLDriver) mysqlIndexExists(index string) (bool, error) {
+	schema := "DATABASE()"
+	var args []interface{}
+	if idx := strings.LastIndexByte(self.table, '.'); idx >= 0 {
+		schema = "?"
+		args = append(args, self.table[:idx])
+	}
+	args = append(args, indexName(self.table), index)
+	var count int
+	err := self.db.QueryRow(fmt.Sprintf(
+		"SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = %s AND table_name = ? AND index_name = ?",
+		schema), args...).Scan(&count)
+	return count > 0, err
+}
+
+// schema.table的索引名去掉schema
+func indexName(table string) string {
+	for idx := len(table) - 1; idx >= 0; idx-- {
+		if table[idx] == '.' {
+			return table[idx+1:]
+		}
+	}
+	return table
+}
+
+func expiresAt(expire time.Duration) int64 {
+	if expire <= 0 {
+		return 0
+	}
+	return time.Now().Add(expire).Unix()
+}
+
+func (self *SQLDriver) Get(key string) ([]byte, error) {
+	var data []byte
+	var expires int64
+	err := self.db.QueryRow(self.getSQL, key).Scan(&data, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expires > 0 && time.Now().Unix() > expires {
+		return nil, nil
+	}
+	return data, nil
+}
+
+func (self *SQLDriver) Set(key string, value []byte, expire time.Duration) error {
+	_, err := self.db.Exec(self.upsertSQL, key, value, expiresAt(expire))
+	return err
+}
+
+// key不存在或已过期时返回nil和版本号0
+func (self *SQLDriver) GetVersion(key string) ([]byte, int64, error) {
+	var data []byte
+	var expires, version int64
+	err := self.db.QueryRow(self.getVersionSQL, key).Scan(&data, &expires, &version)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if expires > 0 && time.Now().Unix() > expires {
+		return nil, 0, nil
+	}
+	return data, version, nil
+}
+
+func (self *SQLDriver) SetIfVersion(key string, value []byte, version int64, expire time.Duration) (int64, error) {
+	now := time.Now().Unix()
+	result, err := self.db.Exec(self.updateVersionSQL, value, expiresAt(expire), version+1, key, version, now)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return version + 1, err
+	}
+	if version != 0 {
+		return 0, ErrSessionConflict
+	}
+	// 版本号为0表示session不存在或已过期, 删除过期的行之后插入, 插入失败说明其他请求已经创建了session
+	if _, err := self.db.Exec(self.deleteExpiredSQL, key, now); err != nil {
+		return 0, err
+	}
+	if _, err := self.db.Exec(self.insertVersionSQL, key, value, expiresAt(expire)); err != nil {
+		if data, _, getErr := self.GetVersion(key); getErr == nil && data != nil {
+			return 0, ErrSessionConflict
+		}
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (self *SQLDriver) Delete(key string) error {
+	_, err := self.db.Exec(self.deleteSQL, key)
+	return err
+}
+
+func (self *SQLDriver) Touch(key string, expire time.Duration) error {
+	_, err := self.db.Exec(self.touchSQL, expiresAt(expire), key)
+	return err
+}
+
+// 删除过期的session, 返回删除的数量
+func (self *SQLDriver) Cleanup() (int64, error) {
+	result, err := self.db.Exec(self.cleanupSQL, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 停止定时清理的goroutine, 不会关闭db
+func (self *SQLDriver) Stop() {
+	self.stopOnce.Do(func() {
+		close(self.stop)
+	})
+}
+
+func (self *SQLDriver) cleaner() {
+	ticker := time.NewTicker(self.cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.Cleanup()
+		case <-self.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,339 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按语句前缀识别SQLDriver生成的几种SQL, 数据按DSN分开保存
+type fakeSQLRow struct {
+	data    []byte
+	expires int64
+	version int64
+}
+
+type fakeSQLStore struct {
+	lock    sync.Mutex
+	rows    map[string]fakeSQLRow
+	queries []string
+}
+
+var fakeSQLStores = struct {
+	sync.Mutex
+	m map[string]*fakeSQLStore
+}{m: make(map[string]*fakeSQLStore)}
+
+type fakeSQLDriver struct{}
+
+func init() {
+	sql.Register("dawn-fake", fakeSQLDriver{})
+}
+
+func (fakeSQLDriver) Open(dsn string) (driver.Conn, error) {
+	fakeSQLStores.Lock()
+	defer fakeSQLStores.Unlock()
+	store, ok := fakeSQLStores.m[dsn]
+	if !ok {
+		store = &fakeSQLStore{rows: make(map[string]fakeSQLRow)}
+		fakeSQLStores.m[dsn] = store
+	}
+	return &fakeSQLConn{store: store}, nil
+}
+
+type fakeSQLConn struct {
+	store *fakeSQLStore
+}
+
+func (self *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{store: self.store, query: query}, nil
+}
+
+func (self *fakeSQLConn) Close() error {
+	return nil
+}
+
+func (self *fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+type fakeSQLStmt struct {
+	store *fakeSQLStore
+	query string
+}
+
+func (self *fakeSQLStmt) Close() error {
+	return nil
+}
+
+func (self *fakeSQLStmt) NumInput() int {
+	return -1
+}
+
+func (self *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	store := self.store
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.queries = append(store.queries, self.query)
+	var affected int64
+	switch {
+	case strings.HasPrefix(self.query, "CREATE"):
+	case strings.HasPrefix(self.query, "INSERT") && strings.Contains(self.query, " ON "):
+		id := args[0].(string)
+		store.rows[id] = fakeSQLRow{data: args[1].([]byte), expires: args[2].(int64), version: store.rows[id].version}
+		affected = 1
+	case strings.HasPrefix(self.query, "INSERT"):
+		id := args[0].(string)
+		if _, ok := store.rows[id]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		store.rows[id] = fakeSQLRow{data: args[1].([]byte), expires: args[2].(int64), version: 1}
+		affected = 1
+	case strings.HasPrefix(self.query, "UPDATE") && strings.Contains(self.query, "version ="):
+		id := args[3].(string)
+		row, ok := store.rows[id]
+		if ok && row.version == args[4].(int64) && (row.expires == 0 || row.expires >= args[5].(int64)) {
+			store.rows[id] = fakeSQLRow{data: args[0].([]byte), expires: args[1].(int64), version: args[2].(int64)}
+			affected = 1
+		}
+	case strings.HasPrefix(self.query, "UPDATE"):
+		id := args[1].(string)
+		if row, ok := store.rows[id]; ok {
+			row.expires = args[0].(int64)
+			store.rows[id] = row
+			affected = 1
+		}
+	case strings.Contains(self.query, "WHERE id") && strings.Contains(self.query, "AND expires"):
+		id := args[0].(string)
+		if row, ok := store.rows[id]; ok && row.expires > 0 && row.expires < args[1].(int64) {
+			delete(store.rows, id)
+			affected = 1
+		}
+	case strings.Contains(self.query, "WHERE id"):
+		if _, ok := store.rows[args[0].(string)]; ok {
+			delete(store.rows, args[0].(string))
+			affected = 1
+		}
+	case strings.Contains(self.query, "WHERE expires"):
+		for id, row := range store.rows {
+			if row.expires > 0 && row.expires < args[0].(int64) {
+				delete(store.rows, id)
+				affected++
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query: " + self.query)
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (self *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	store := self.store
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.queries = append(store.queries, self.query)
+	if strings.HasPrefix(self.query, "SELECT COUNT(*) FROM information_schema") {
+		var count int64
+		for _, query := range store.queries {
+			if strings.HasPrefix(query, "CREATE INDEX") {
+				count++
+			}
+		}
+		return &fakeSQLRows{values: []driver.Value{count}}, nil
+	}
+	row, ok := store.rows[args[0].(string)]
+	values := []driver.Value{row.data, row.expires}
+	if strings.Contains(self.query, "version") {
+		values = append(values, row.version)
+	}
+	return &fakeSQLRows{values: values, done: !ok}, nil
+}
+
+type fakeSQLRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (self *fakeSQLRows) Columns() []string {
+	return []string{"data", "expires", "version"}[:len(self.values)]
+}
+
+func (self *fakeSQLRows) Close() error {
+	return nil
+}
+
+func (self *fakeSQLRows) Next(dest []driver.Value) error {
+	if self.done {
+		return io.EOF
+	}
+	self.done = true
+	copy(dest, self.values)
+	return nil
+}
+
+func openFakeSQL(t *testing.T) (*sql.DB, *fakeSQLStore) {
+	db, err := sql.Open("dawn-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	fakeSQLStores.Lock()
+	defer fakeSQLStores.Unlock()
+	return db, fakeSQLStores.m[t.Name()]
+}
+
+func TestSQLDriver(t *testing.T) {
+	db, store := openFakeSQL(t)
+	defer db.Close()
+	driver, err := NewSQLDriver(db, PostgresDialect, SQLTable("web.sessions"), SQLCleanupInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Stop()
+	if err := driver.Migrate(); err != nil {
+		t.Fatal("Migrate error:", err)
+	}
+
+	if err := driver.Set("sid", []byte("dawn"), time.Hour); err != nil {
+		t.Fatal("Set error:", err)
+	}
+	value, err := driver.Get("sid")
+	if err != nil || string(value) != "dawn" {
+		t.Error("Get error:", string(value), err)
+	}
+	if value, err := driver.Get("none"); value != nil || err != nil {
+		t.Error("Unknown key should return nil:", value, err)
+	}
+	driver.Set("sid", []byte("pungle"), time.Hour)
+	if value, _ := driver.Get("sid"); string(value) != "pungle" {
+		t.Error("Upsert error:", string(value))
+	}
+
+	driver.Set("old", []byte("old"), time.Hour)
+	driver.Touch("old", time.Hour)
+	store.rows["old"] = fakeSQLRow{data: []byte("old"), expires: time.Now().Add(-time.Minute).Unix()}
+	if value, _ := driver.Get("old"); value != nil {
+		t.Error("Expired session should not be returned")
+	}
+	if n, err := driver.Cleanup(); n != 1 || err != nil {
+		t.Error("Cleanup error:", n, err)
+	}
+	driver.Delete("sid")
+	if len(store.rows) != 0 {
+		t.Error("Delete error:", store.rows)
+	}
+
+	queries := strings.Join(store.queries, "\n")
+	for _, expected := range []string{
+		"CREATE TABLE IF NOT EXISTS web.sessions (id VARCHAR(128) NOT NULL PRIMARY KEY, data BYTEA",
+		"CREATE INDEX IF NOT EXISTS sessions_expires ON web.sessions (expires)",
+		"VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE",
+		"UPDATE web.sessions SET expires = $1 WHERE id = $2",
+	} {
+		if !strings.Contains(queries, expected) {
+			t.Error("Query not found:", expected, "\n", queries)
+		}
+	}
+}
+
+func TestSQLDriverDialect(t *testing.T) {
+	db, store := openFakeSQL(t)
+	defer db.Close()
+	if _, err := NewSQLDriver(db, MySQLDialect, SQLTable("sessions; DROP TABLE users")); err != ErrInvalidTableName {
+		t.Error("Invalid table name should be rejected:", err)
+	}
+	if _, err := NewSQLDriver(db, nil); err != ErrInvalidSQLDialect {
+		t.Error("Nil dialect should be rejected:", err)
+	}
+	driver, _ := NewSQLDriver(db, MySQLDialect, SQLCleanupInterval(10*time.Millisecond))
+	defer driver.Stop()
+	driver.Set("sid", []byte("dawn"), 0)
+	store.lock.Lock()
+	store.rows["expired"] = fakeSQLRow{data: []byte("old"), expires: 1}
+	store.lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.rows["expired"]; ok {
+		t.Error("Expired session should be cleaned up")
+	}
+	if row, ok := store.rows["sid"]; !ok || row.expires != 0 {
+		t.Error("Session without expire should be kept:", row)
+	}
+	if !strings.Contains(store.queries[0], "VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE") {
+		t.Error("MySQL upsert error:", store.queries[0])
+	}
+}
+
+func TestSQLDriverMySQLMigrate(t *testing.T) {
+	db, store := openFakeSQL(t)
+	defer db.Close()
+	driver, _ := NewSQLDriver(db, MySQLDialect, SQLTable("app.sessions"), SQLCleanupInterval(0))
+	for i := 0; i < 2; i++ {
+		if err := driver.Migrate(); err != nil {
+			t.Fatal("Migrate error:", err)
+		}
+	}
+	var indexes int
+	for _, query := range store.queries {
+		if strings.HasPrefix(query, "CREATE INDEX sessions_expires ON app.sessions") {
+			indexes++
+		}
+	}
+	if indexes != 1 {
+		t.Error("Existing index should not be created again:", store.queries)
+	}
+}
+
+func TestSQLDriverVersion(t *testing.T) {
+	db, store := openFakeSQL(t)
+	defer db.Close()
+	var driver VersionedSessionDriver
+	driver, _ = NewSQLDriver(db, SQLiteDialect, SQLCleanupInterval(0))
+
+	if version, err := driver.SetIfVersion("sid", []byte("a"), 0, time.Hour); version != 1 || err != nil {
+		t.Error("SetIfVersion error:", version, err)
+	}
+	if _, err := driver.SetIfVersion("sid", []byte("b"), 0, time.Hour); err != ErrSessionConflict {
+		t.Error("Creating an existing session should conflict:", err)
+	}
+	if version, err := driver.SetIfVersion("sid", []byte("b"), 1, time.Hour); version != 2 || err != nil {
+		t.Error("SetIfVersion update error:", version, err)
+	}
+	if _, err := driver.SetIfVersion("sid", []byte("c"), 1, time.Hour); err != ErrSessionConflict {
+		t.Error("Stale version should conflict:", err)
+	}
+	value, version, err := driver.GetVersion("sid")
+	if string(value) != "b" || version != 2 || err != nil {
+		t.Error("GetVersion error:", string(value), version, err)
+	}
+
+	// Set写入的行版本号为0
+	driver.Set("plain", []byte("a"), 0)
+	if value, version, _ := driver.GetVersion("plain"); string(value) != "a" || version != 0 {
+		t.Error("GetVersion of plain row error:", string(value), version)
+	}
+	if version, err := driver.SetIfVersion("plain", []byte("b"), 0, 0); version != 1 || err != nil {
+		t.Error("SetIfVersion of plain row error:", version, err)
+	}
+
+	store.lock.Lock()
+	store.rows["old"] = fakeSQLRow{data: []byte("old"), expires: 1, version: 3}
+	store.lock.Unlock()
+	if value, version, _ := driver.GetVersion("old"); value != nil || version != 0 {
+		t.Error("Expired session should return nil:", value, version)
+	}
+	if version, err := driver.SetIfVersion("old", []byte("new"), 0, time.Hour); version != 1 || err != nil {
+		t.Error("Expired session should be replaced:", version, err)
+	}
+}
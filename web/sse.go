@@ -0,0 +1,190 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStreamingNotSupported = errors.New("StreamingNotSupported")
+	ErrStreamClosed          = errors.New("EventStreamClosed")
+)
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration // 告诉客户端断线重连的间隔, 0表示不发送
+}
+
+// EventStream是text/event-stream的写入端, 可以在多个goroutine中同时使用
+type EventStream struct {
+	resp    http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+
+	lastEventID string
+
+	lock   sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// 开始Server-Sent Events响应, 客户端断开或请求超时后Done()会被关闭.
+// 长时间的事件流需要使用RouteTimeout(0)关闭路由的处理超时
+func (self *HttpContext) SSE() (*EventStream, error) {
+	resp := self.Response
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingNotSupported
+	}
+	header := resp.Header()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	stream := &EventStream{
+		resp:        resp,
+		flusher:     flusher,
+		ctx:         self.Context(),
+		lastEventID: self.Request.Header.Get("Last-Event-ID"),
+		done:        make(chan struct{}),
+	}
+	self.stream = stream
+	go stream.watch()
+	return stream, nil
+}
+
+func (self *EventStream) watch() {
+	select {
+	case <-self.ctx.Done():
+		self.Close()
+	case <-self.done:
+	}
+}
+
+// 客户端重连时带上的Last-Event-ID, 用于从断开的位置继续发送
+func (self *EventStream) LastEventID() string {
+	return self.lastEventID
+}
+
+func (self *EventStream) Done() <-chan struct{} {
+	return self.done
+}
+
+func (self *EventStream) Send(event *Event) error {
+	var buf bytes.Buffer
+	if event.ID != "" {
+		buf.WriteString("id: ")
+		buf.WriteString(sanitizeField(event.ID))
+		buf.WriteByte('\n')
+	}
+	if event.Event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(sanitizeField(event.Event))
+		buf.WriteByte('\n')
+	}
+	if event.Retry > 0 {
+		buf.WriteString("retry: ")
+		buf.WriteString(strconv.FormatInt(int64(event.Retry/time.Millisecond), 10))
+		buf.WriteByte('\n')
+	}
+	for _, line := range splitLines(event.Data) {
+		buf.WriteString("data: ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return self.write(buf.Bytes())
+}
+
+func (self *EventStream) SendData(data string) error {
+	return self.Send(&Event{Data: data})
+}
+
+func (self *EventStream) SendJSON(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return self.Send(&Event{Event: event, Data: string(data)})
+}
+
+// 发送注释行, 客户端会忽略它, 一般用于保持连接
+func (self *EventStream) Comment(text string) error {
+	var buf bytes.Buffer
+	for _, line := range splitLines(text) {
+		buf.WriteString(": ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return self.write(buf.Bytes())
+}
+
+// 每隔interval发送一次注释, 防止代理因为空闲断开连接, 事件流关闭后自动停止
+func (self *EventStream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if self.Comment("ping") != nil {
+					return
+				}
+			case <-self.done:
+				return
+			}
+		}
+	}()
+}
+
+func (self *EventStream) write(data []byte) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return ErrStreamClosed
+	}
+	if _, err := self.resp.Write(data); err != nil {
+		self.closeLocked()
+		return err
+	}
+	self.flusher.Flush()
+	return nil
+}
+
+func (self *EventStream) Close() {
+	self.lock.Lock()
+	self.closeLocked()
+	self.lock.Unlock()
+}
+
+func (self *EventStream) closeLocked() {
+	if !self.closed {
+		self.closed = true
+		close(self.done)
+	}
+}
+
+// \r\n, \r和\n都是事件流的换行符
+func splitLines(value string) []string {
+	value = strings.Replace(value, "\r\n", "\n", -1)
+	value = strings.Replace(value, "\r", "\n", -1)
+	return strings.Split(value, "\n")
+}
+
+func sanitizeField(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
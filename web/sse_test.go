@@ -0,0 +1,69 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSE(t *testing.T) {
+	server := newTestServer()
+	closed := make(chan struct{})
+	server.AddHandler("/events", func(ctx *HttpContext) {
+		stream, err := ctx.SSE()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		stream.Send(&Event{ID: "2", Event: "msg", Data: "resume from " + stream.LastEventID(), Retry: time.Second})
+		stream.SendData("line1\nline2")
+		stream.SendData("x\revent: admin\r\ny")
+		stream.Heartbeat(10 * time.Millisecond)
+		<-stream.Done()
+		close(closed)
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Error("SSE content type error:", resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Connection") != "" {
+		t.Error("SSE should not set Connection header")
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	expected := []string{
+		"id: 2", "event: msg", "retry: 1000", "data: resume from 1", "",
+		"data: line1", "data: line2", "",
+		"data: x", "data: event: admin", "data: y", "",
+		": ping", "",
+	}
+	for _, line := range expected {
+		got, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimRight(got, "\n") != line {
+			t.Errorf("SSE line error: %q != %q", got, line)
+		}
+	}
+
+	resp.Body.Close()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("Stream was not closed after client disconnected")
+	}
+}
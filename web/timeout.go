@@ -0,0 +1,173 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrHandlerTimeout = errors.New("HandlerTimeout")
+)
+
+// timeoutWriter在超时之后拒绝handler的所有写入, 避免迟到的写操作破坏已发送的超时响应.
+// handler使用独立的header, 直到WriteHeader时才复制到底层ResponseWriter.
+// 只在底层支持时实现http.Flusher; 不实现http.Hijacker和io.ReaderFrom,
+// 劫持的连接和ReadFrom都无法在超时后拦截写入, 需要它们的路由应使用RouteTimeout(0)
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	lock        sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func newTimeoutWriter(w http.ResponseWriter) (http.ResponseWriter, *timeoutWriter) {
+	h := make(http.Header)
+	for k, v := range w.Header() {
+		h[k] = v
+	}
+	tw := &timeoutWriter{w: w, h: h}
+	if f, ok := w.(http.Flusher); ok {
+		return struct {
+			*timeoutWriter
+			http.Flusher
+		}{tw, &twFlusher{tw, f}}, tw
+	}
+	return tw, tw
+}
+
+func (self *timeoutWriter) unwrapTimeout() *timeoutWriter {
+	return self
+}
+
+func (self *timeoutWriter) Header() http.Header {
+	return self.h
+}
+
+func (self *timeoutWriter) WriteHeader(code int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timedOut {
+		return
+	}
+	self.writeHeaderLocked(code)
+}
+
+func (self *timeoutWriter) writeHeaderLocked(code int) {
+	if self.wroteHeader {
+		return
+	}
+	self.wroteHeader = true
+	dst := self.w.Header()
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range self.h {
+		dst[k] = v
+	}
+	self.w.WriteHeader(code)
+}
+
+func (self *timeoutWriter) Write(value []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timedOut {
+		return 0, ErrHandlerTimeout
+	}
+	self.writeHeaderLocked(http.StatusOK)
+	return self.w.Write(value)
+}
+
+type twFlusher struct {
+	tw *timeoutWriter
+	f  http.Flusher
+}
+
+func (self *twFlusher) Flush() {
+	self.tw.lock.Lock()
+	defer self.tw.lock.Unlock()
+	if self.tw.timedOut {
+		return
+	}
+	self.tw.writeHeaderLocked(http.StatusOK)
+	self.f.Flush()
+}
+
+func (self *timeoutWriter) timeout(code int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.timedOut = true
+	if self.wroteHeader || code == 0 {
+		return
+	}
+	self.wroteHeader = true
+	self.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// handler可能还在运行, 不能在这里执行保存session等回调
+	if rw, ok := self.w.(interface{ writeHeader(int, bool) }); ok {
+		rw.writeHeader(code, false)
+	} else {
+		self.w.WriteHeader(code)
+	}
+	self.w.Write([]byte(http.StatusText(code)))
+}
+
+// 读取响应状态. 超时的路由中timeoutWriter会在另一个goroutine写入响应头, 需要在它的锁内读取
+func (self *HttpContext) responseState() (wroteHeader bool, hijacked bool, timedOut bool) {
+	if w, ok := self.Response.(interface{ unwrapTimeout() *timeoutWriter }); ok {
+		tw := w.unwrapTimeout()
+		tw.lock.Lock()
+		defer tw.lock.Unlock()
+		return self.info.wroteHeader, self.info.hijacked, tw.timedOut
+	}
+	return self.info.wroteHeader, self.info.hijacked, false
+}
+
+func (self *HttpContext) headerWritten() bool {
+	wroteHeader, _, _ := self.responseState()
+	return wroteHeader
+}
+
+func serveWithTimeout(ctx *HttpContext, handler Handler, timeout time.Duration, code int) {
+	c, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+	defer cancel()
+
+	resp, tw := newTimeoutWriter(ctx.Response)
+	ctx.Request = ctx.Request.WithContext(c)
+	ctx.Response = resp
+
+	done := make(chan struct{})
+	panicChan := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		handler(ctx)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+	case <-c.Done():
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if c.Err() == context.DeadlineExceeded {
+			tw.timeout(code)
+		} else {
+			// 客户端已断开, 无需再写响应
+			tw.timeout(0)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer() *HttpServer {
+	return NewServer(NewHttpConfig(":0"), nil, &discardHandler{})
+}
+
+type discardHandler struct{}
+
+func (self *discardHandler) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestRouteTimeout(t *testing.T) {
+	server := newTestServer()
+	lateWrite := make(chan error, 1)
+	server.AddHandler("/slow", func(ctx *HttpContext) {
+		<-ctx.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := ctx.Response.Write([]byte("late"))
+		lateWrite <- err
+	}, RouteTimeout(20*time.Millisecond))
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/slow", nil))
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Error("Timeout status error:", resp.Code)
+	}
+	if err := <-lateWrite; err != ErrHandlerTimeout {
+		t.Error("Late write should be rejected:", err)
+	}
+	if resp.Body.String() != http.StatusText(http.StatusServiceUnavailable) {
+		t.Error("Timeout body was corrupted:", resp.Body.String())
+	}
+}
+
+func TestRouteTimeoutOverride(t *testing.T) {
+	config := NewHttpConfig(":0",
+		WithHandlerTimeout(10*time.Millisecond),
+		WithTimeoutStatus(http.StatusGatewayTimeout),
+	)
+	server := NewServer(config, nil, &discardHandler{})
+	handler := func(ctx *HttpContext) {
+		time.Sleep(30 * time.Millisecond)
+		ctx.Response.Write([]byte("ok"))
+	}
+	server.AddHandler("/global", handler)
+	server.AddHandler("/unlimited", handler, RouteTimeout(0))
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/global", nil))
+	if resp.Code != http.StatusGatewayTimeout {
+		t.Error("Global timeout status error:", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/unlimited", nil))
+	if resp.Code != http.StatusOK || resp.Body.String() != "ok" {
+		t.Error("Route timeout override error:", resp.Code, resp.Body.String())
+	}
+}
+
+func TestRouteTimeoutBodyCount(t *testing.T) {
+	server := newTestServer()
+	done := make(chan struct{})
+	server.AddHandler("/upload", func(ctx *HttpContext) {
+		<-ctx.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		// 超时后handler仍在读取body, 同时访问日志在统计读取的字节数
+		io.Copy(io.Discard, ctx.Request.Body)
+		close(done)
+	}, RouteTimeout(10*time.Millisecond))
+
+	body := strings.NewReader(strings.Repeat("x", 1<<16))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("POST", "/upload", body))
+	<-done
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Error("Timeout status error:", resp.Code)
+	}
+}
+
+type plainResponseWriter struct {
+	http.ResponseWriter
+}
+
+func TestTimeoutWriterInterfaces(t *testing.T) {
+	resp, tw := newTimeoutWriter(httptest.NewRecorder())
+	if _, ok := resp.(http.Flusher); !ok {
+		t.Error("Timeout writer should keep http.Flusher")
+	}
+	if _, ok := resp.(http.Hijacker); ok {
+		t.Error("Timeout writer should not expose http.Hijacker")
+	}
+	if _, ok := resp.(interface{ unwrapTimeout() *timeoutWriter }); !ok {
+		t.Error("Timeout writer state is not reachable")
+	}
+	tw.timeout(http.StatusServiceUnavailable)
+	resp.(http.Flusher).Flush()
+
+	resp, _ = newTimeoutWriter(&plainResponseWriter{httptest.NewRecorder()})
+	if _, ok := resp.(http.Flusher); ok {
+		t.Error("Timeout writer should not expose http.Flusher")
+	}
+}
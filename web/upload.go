@@ -0,0 +1,345 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+)
+
+var (
+	DEFAULT_MAX_MEMORY int64 = 32 << 20
+	MAX_VALUE_MEMORY   int64 = 10 << 20 // 普通表单字段总共可使用的内存
+)
+
+var (
+	ErrBodyTooLarge = errors.New("RequestBodyTooLarge")
+	ErrNotMultipart = errors.New("RequestNotMultipart")
+	ErrTooManyFiles = errors.New("TooManyUploadFiles")
+	ErrPartTooLarge = errors.New("UploadPartTooLarge")
+	ErrMissingFile  = errors.New("UploadFileNotFound")
+	ErrFormConsumed = errors.New("MultipartBodyConsumed")
+)
+
+type UploadLimits struct {
+	MaxFiles    int   // 单次请求最多的文件数, <=0表示不限制
+	MaxPartSize int64 // 单个part的最大字节数, <=0表示不限制
+	MaxMemory   int64 // 文件超过该大小后写入临时文件, <=0使用DEFAULT_MAX_MEMORY
+}
+
+func (self UploadLimits) maxMemory() int64 {
+	if self.MaxMemory <= 0 {
+		return DEFAULT_MAX_MEMORY
+	}
+	return self.MaxMemory
+}
+
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrBodyTooLarge
+	}
+	return err
+}
+
+// 记录请求体是否超过了大小限制, 处理函数没有写入响应时由框架返回413
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (self *limitedBody) Read(p []byte) (int, error) {
+	n, err := self.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		self.exceeded = true
+	}
+	return n, err
+}
+
+func (self *HttpContext) bodyTooLarge() {
+	code := http.StatusRequestEntityTooLarge
+	http.Error(self.Response, http.StatusText(code), code)
+}
+
+//------------------ MultipartReader ------------------
+
+// MultipartReader按顺序流式读取上传内容, 并对文件数和单个part的大小做限制
+type MultipartReader struct {
+	reader *multipart.Reader
+	limits UploadLimits
+	files  int
+}
+
+type UploadPart struct {
+	part      *multipart.Part
+	remaining int64
+	limited   bool
+}
+
+func (self *UploadPart) FormName() string {
+	return self.part.FormName()
+}
+
+func (self *UploadPart) FileName() string {
+	return self.part.FileName()
+}
+
+func (self *UploadPart) Header() textproto.MIMEHeader {
+	return self.part.Header
+}
+
+func (self *UploadPart) Read(p []byte) (int, error) {
+	if !self.limited {
+		n, err := self.part.Read(p)
+		return n, bodyError(err)
+	}
+	if self.remaining < 0 {
+		return 0, ErrPartTooLarge
+	}
+	// 多读一个字节用来判断是否超出限制
+	if int64(len(p)) > self.remaining+1 {
+		p = p[:self.remaining+1]
+	}
+	n, err := self.part.Read(p)
+	self.remaining -= int64(n)
+	if self.remaining < 0 {
+		return n + int(self.remaining), ErrPartTooLarge
+	}
+	return n, bodyError(err)
+}
+
+func (self *MultipartReader) NextPart() (*UploadPart, error) {
+	part, err := self.reader.NextPart()
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	if part.FileName() != "" {
+		self.files++
+		if self.limits.MaxFiles > 0 && self.files > self.limits.MaxFiles {
+			part.Close()
+			return nil, ErrTooManyFiles
+		}
+	}
+	limited := self.limits.MaxPartSize > 0
+	return &UploadPart{part, self.limits.MaxPartSize, limited}, nil
+}
+
+// 读取全部内容, 超过MaxMemory的文件会写入临时文件, 使用完毕后需要调用RemoveAll
+func (self *MultipartReader) ReadForm() (*UploadForm, error) {
+	form := &UploadForm{
+		Value: make(map[string][]string),
+		File:  make(map[string][]*UploadedFile),
+	}
+	if err := self.readForm(form); err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (self *MultipartReader) readForm(form *UploadForm) error {
+	memory := self.limits.maxMemory()
+	valueMemory := MAX_VALUE_MEMORY
+	for {
+		part, err := self.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, part, valueMemory+1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			valueMemory -= n
+			if valueMemory < 0 {
+				return ErrPartTooLarge
+			}
+			form.Value[name] = append(form.Value[name], buf.String())
+			continue
+		}
+		file, err := readUploadedFile(part, memory)
+		if err != nil {
+			return err
+		}
+		if file.tmpfile == "" {
+			memory -= file.Size
+		}
+		form.File[name] = append(form.File[name], file)
+	}
+}
+
+func readUploadedFile(part *UploadPart, memory int64) (*UploadedFile, error) {
+	file := &UploadedFile{
+		Filename: filepath.Base(part.FileName()),
+		Header:   part.Header(),
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, memory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= memory {
+		file.Size = n
+		file.content = buf.Bytes()
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp("", "dawn-upload-")
+	if err != nil {
+		return nil, err
+	}
+	file.tmpfile = tmp.Name()
+	size, err := io.Copy(tmp, io.MultiReader(&buf, part))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.tmpfile)
+		return nil, err
+	}
+	file.Size = size
+	return file, nil
+}
+
+//------------------ UploadForm ------------------
+
+type UploadForm struct {
+	Value map[string][]string
+	File  map[string][]*UploadedFile
+}
+
+func (self *UploadForm) RemoveAll() error {
+	var err error
+	for _, files := range self.File {
+		for _, file := range files {
+			if file.tmpfile == "" {
+				continue
+			}
+			if e := os.Remove(file.tmpfile); e != nil && !os.IsNotExist(e) && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+type UploadedFile struct {
+	Filename string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+func (self *UploadedFile) Open() (io.ReadCloser, error) {
+	if self.tmpfile != "" {
+		return os.Open(self.tmpfile)
+	}
+	return io.NopCloser(bytes.NewReader(self.content)), nil
+}
+
+func (self *UploadedFile) Save(dst string) error {
+	src, err := self.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, src)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//------------------ HttpContext helpers ------------------
+
+func (self *HttpContext) uploadLimits() UploadLimits {
+	if self.route == nil {
+		return UploadLimits{}
+	}
+	return self.route.uploadLimits()
+}
+
+// 返回流式读取上传内容的MultipartReader, 与FormFile不能同时使用
+func (self *HttpContext) MultipartReader() (*MultipartReader, error) {
+	if self.multipartUsed {
+		return nil, ErrFormConsumed
+	}
+	contentType := self.Request.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "multipart/form-data" && mediaType != "multipart/mixed") {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, ErrNotMultipart
+	}
+	self.multipartUsed = true
+	reader := multipart.NewReader(self.Request.Body, boundary)
+	return &MultipartReader{reader: reader, limits: self.uploadLimits()}, nil
+}
+
+// 解析整个上传表单, 结果会被缓存, 临时文件在请求结束后自动删除
+func (self *HttpContext) UploadForm() (*UploadForm, error) {
+	if self.uploadForm != nil {
+		return self.uploadForm, nil
+	}
+	reader, err := self.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	form, err := reader.ReadForm()
+	if err != nil {
+		return nil, err
+	}
+	self.uploadForm = form
+	return form, nil
+}
+
+func (self *HttpContext) FormFile(name string) (*UploadedFile, error) {
+	form, err := self.UploadForm()
+	if err != nil {
+		return nil, err
+	}
+	files := form.File[name]
+	if len(files) == 0 {
+		return nil, ErrMissingFile
+	}
+	return files[0], nil
+}
+
+func (self *HttpContext) SaveUploadedFile(file *UploadedFile, dst string) error {
+	return file.Save(dst)
+}
+
+func (self *HttpContext) cleanupUpload() {
+	if self.uploadForm != nil {
+		self.uploadForm.RemoveAll()
+		self.uploadForm = nil
+	}
+}
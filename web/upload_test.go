@@ -0,0 +1,150 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newUploadRequest(t *testing.T, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("title", "hello")
+	for name, content := range files {
+		part, err := writer.CreateFormFile(name, name+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	writer.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestMaxBodySize(t *testing.T) {
+	server := newTestServer()
+	called := false
+	server.AddHandler("/upload", func(ctx *HttpContext) {
+		called = true
+	}, RouteMaxBodySize(4))
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("POST", "/upload", strings.NewReader("123456")))
+	if resp.Code != http.StatusRequestEntityTooLarge || called {
+		t.Error("Body limit error:", resp.Code, called)
+	}
+
+	// 长度未知的请求体在读取时超过限制
+	var readErr, bindErr error
+	server.AddHandler("/chunked", func(ctx *HttpContext) {
+		_, readErr = io.ReadAll(ctx.Request.Body)
+	}, RouteMaxBodySize(4))
+	server.AddHandler("/bind", func(ctx *HttpContext) {
+		var form struct {
+			Name string `form:"name"`
+		}
+		bindErr = ctx.Bind(&form)
+		if bindErr != nil {
+			return
+		}
+		ctx.Response.Write([]byte("ok"))
+	}, RouteMaxBodySize(4))
+	req := httptest.NewRequest("POST", "/chunked", strings.NewReader("123456"))
+	req.ContentLength = -1
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if resp.Code != http.StatusRequestEntityTooLarge || readErr == nil {
+		t.Error("Chunked body limit error:", resp.Code, readErr)
+	}
+	req = httptest.NewRequest("POST", "/bind", strings.NewReader("name=123456"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ContentLength = -1
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if resp.Code != http.StatusRequestEntityTooLarge || bindErr != ErrBodyTooLarge {
+		t.Error("Bind body limit error:", resp.Code, bindErr)
+	}
+}
+
+func TestFormFile(t *testing.T) {
+	dir := t.TempDir()
+	server := NewServer(NewHttpConfig(":0", WithUploadLimits(UploadLimits{MaxMemory: 4})), nil, &discardHandler{})
+	var tmpfile string
+	server.AddHandler("/upload", func(ctx *HttpContext) {
+		file, err := ctx.FormFile("avatar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Filename != "avatar.txt" || file.Size != 10 {
+			t.Error("Upload file info error:", file.Filename, file.Size)
+		}
+		tmpfile = file.tmpfile
+		if tmpfile == "" {
+			t.Error("Large file should spill to temp file")
+		}
+		if err = ctx.SaveUploadedFile(file, filepath.Join(dir, "a", file.Filename)); err != nil {
+			t.Error(err)
+		}
+		form, _ := ctx.UploadForm()
+		if form.Value["title"][0] != "hello" {
+			t.Error("Form value error:", form.Value)
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, newUploadRequest(t, map[string]string{"avatar": "0123456789"}))
+	data, err := os.ReadFile(filepath.Join(dir, "a", "avatar.txt"))
+	if err != nil || string(data) != "0123456789" {
+		t.Error("Saved file error:", string(data), err)
+	}
+	if _, err = os.Stat(tmpfile); !os.IsNotExist(err) {
+		t.Error("Temp file was not removed after request:", err)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	server := newTestServer()
+	var filesErr, partErr error
+	server.AddHandler("/upload", func(ctx *HttpContext) {
+		_, filesErr = ctx.UploadForm()
+	}, RouteUploadLimits(UploadLimits{MaxFiles: 1}))
+	server.AddHandler("/part", func(ctx *HttpContext) {
+		reader, err := ctx.MultipartReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if _, err = io.ReadAll(part); err != nil {
+				partErr = err
+				return
+			}
+		}
+	}, RouteUploadLimits(UploadLimits{MaxPartSize: 5}))
+
+	files := map[string]string{"a": "1", "b": "2"}
+	server.ServeHTTP(httptest.NewRecorder(), newUploadRequest(t, files))
+	if filesErr != ErrTooManyFiles {
+		t.Error("File count limit error:", filesErr)
+	}
+
+	req := newUploadRequest(t, map[string]string{"a": "0123456789"})
+	req.URL.Path = "/part"
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	if partErr != ErrPartTooLarge {
+		t.Error("Part size limit error:", partErr)
+	}
+}
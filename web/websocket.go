@@ -0,0 +1,591 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pungle/dawn/logging"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	WS_CONTINUATION = 0
+	WS_TEXT         = 1
+	WS_BINARY       = 2
+	WS_CLOSE        = 8
+	WS_PING         = 9
+	WS_PONG         = 10
+)
+
+const (
+	WS_CLOSE_NORMAL           = 1000
+	WS_CLOSE_GOING_AWAY       = 1001
+	WS_CLOSE_PROTOCOL_ERROR   = 1002
+	WS_CLOSE_UNSUPPORTED_DATA = 1003
+	WS_CLOSE_NO_STATUS        = 1005
+	WS_CLOSE_ABNORMAL         = 1006
+	WS_CLOSE_INVALID_PAYLOAD  = 1007
+	WS_CLOSE_POLICY_VIOLATION = 1008
+	WS_CLOSE_TOO_LARGE        = 1009
+	WS_CLOSE_INTERNAL_ERROR   = 1011
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	DEFAULT_WS_MAX_MESSAGE_SIZE int64 = 1 << 20
+	DEFAULT_WS_CLOSE_TIMEOUT          = 5 * time.Second
+)
+
+var (
+	ErrWSBadHandshake      = errors.New("WebSocketBadHandshake")
+	ErrWSOriginNotAllowed  = errors.New("WebSocketOriginNotAllowed")
+	ErrWSHijackUnsupported = errors.New("WebSocketHijackNotSupported")
+	ErrWSClosed            = errors.New("WebSocketClosed")
+)
+
+type WSHandler func(conn *WSConn, ctx *HttpContext)
+
+type WSCloseError struct {
+	Code int
+	Text string
+}
+
+func (self *WSCloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", self.Code, self.Text)
+}
+
+type wsOptions struct {
+	maxMessageSize    int64
+	writeFragmentSize int
+	pingInterval      time.Duration
+	subprotocols      []string
+	checkOrigin       func(*http.Request) bool
+	beforeUpgrade     func(*HttpContext) bool
+}
+
+// 单条消息(包括所有分片)的最大字节数, 超出时以1009关闭连接, <=0时使用DEFAULT_WS_MAX_MESSAGE_SIZE
+func WSMaxMessageSize(size int64) RouteOption {
+	return func(r *route) {
+		r.ws.maxMessageSize = size
+	}
+}
+
+// 发送消息时每个分片的最大字节数, 0表示不分片
+func WSWriteFragmentSize(size int) RouteOption {
+	return func(r *route) {
+		r.ws.writeFragmentSize = size
+	}
+}
+
+// 定时发送ping, 超过两个周期没有收到任何数据时断开连接
+func WSPingInterval(interval time.Duration) RouteOption {
+	return func(r *route) {
+		r.ws.pingInterval = interval
+	}
+}
+
+func WSSubprotocols(protocols ...string) RouteOption {
+	return func(r *route) {
+		r.ws.subprotocols = protocols
+	}
+}
+
+// 默认只允许Origin与ctx.Host()相同的请求
+func WSCheckOrigin(check func(*http.Request) bool) RouteOption {
+	return func(r *route) {
+		r.ws.checkOrigin = check
+	}
+}
+
+// 在握手之前调用, 可以检查session或设置响应头(比如保存session写入的cookie),
+// 返回false时放弃升级, 此时fn需要自己写入响应
+func WSBeforeUpgrade(fn func(ctx *HttpContext) bool) RouteOption {
+	return func(r *route) {
+		r.ws.beforeUpgrade = fn
+	}
+}
+
+func (self *HttpServer) WebSocket(urlPattern string, handler WSHandler, opts ...RouteOption) error {
+	opts = append([]RouteOption{RouteTimeout(0)}, opts...)
+	return self.AddHandler(urlPattern, wsHandler(handler), opts...)
+}
+
+func wsHandler(handler WSHandler) Handler {
+	return func(ctx *HttpContext) {
+		conn, err := upgradeWebSocket(ctx)
+		if err != nil {
+			logging.Warn("WebSocket upgrade error: %s, uri: %s", err.Error(), ctx.Request.RequestURI)
+			return
+		}
+		defer conn.release()
+		handler(conn, ctx)
+	}
+}
+
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 通过可信代理访问时使用转发头中的host
+func sameOrigin(ctx *HttpContext) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, ctx.Host())
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func upgradeWebSocket(ctx *HttpContext) (*WSConn, error) {
+	req := ctx.Request
+	resp := ctx.Response
+	var options wsOptions
+	if ctx.route != nil {
+		options = ctx.route.ws
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != "GET" ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		resp.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(resp, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, ErrWSBadHandshake
+	}
+	checkOrigin := options.checkOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(*http.Request) bool { return sameOrigin(ctx) }
+	}
+	if !checkOrigin(req) {
+		http.Error(resp, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, ErrWSOriginNotAllowed
+	}
+	if options.beforeUpgrade != nil && !options.beforeUpgrade(ctx) {
+		return nil, ErrWSBadHandshake
+	}
+	hijacker, ok := resp.(http.Hijacker)
+	if !ok {
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, ErrWSHijackUnsupported
+	}
+
+	subprotocol := ""
+	for _, protocol := range options.subprotocols {
+		if headerContains(req.Header, "Sec-WebSocket-Protocol", protocol) {
+			subprotocol = protocol
+			break
+		}
+	}
+
+	// 接管连接后不会再写入响应头, 需要先执行自动保存session等回调, 写入的cookie随握手响应发送
+	ctx.info.runBeforeHeader(resp)
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if rw.Reader.Buffered() > 0 {
+		// 握手完成前客户端不应该发送数据
+		netConn.Close()
+		return nil, ErrWSBadHandshake
+	}
+
+	// 握手响应中带上handler之前设置的响应头, 比如session的cookie
+	header := make(http.Header)
+	for k, v := range resp.Header() {
+		if k == "Content-Type" || k == "Content-Length" {
+			continue
+		}
+		header[k] = v
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", wsAcceptKey(key))
+	if subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	rw.Writer.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(rw.Writer)
+	rw.Writer.WriteString("\r\n")
+	if err = rw.Writer.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	maxMessageSize := options.maxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DEFAULT_WS_MAX_MESSAGE_SIZE
+	}
+	conn := newWSConn(netConn, rw.Reader, maxMessageSize)
+	conn.Subprotocol = subprotocol
+	conn.writeFragmentSize = options.writeFragmentSize
+	if options.pingInterval > 0 {
+		conn.keepalive(options.pingInterval)
+	}
+	return conn, nil
+}
+
+//------------------ WSConn ------------------
+
+// WSConn同一时间只能有一个goroutine读取, 写入可以并发
+type WSConn struct {
+	Subprotocol string
+
+	conn   net.Conn
+	reader *bufio.Reader
+
+	maxMessageSize    int64
+	writeFragmentSize int
+	readTimeout       time.Duration
+
+	writeLock   sync.Mutex
+	closeSent   bool
+	pingHandler func(data string) error
+	pongHandler func(data string) error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSConn(conn net.Conn, reader *bufio.Reader, maxMessageSize int64) *WSConn {
+	self := &WSConn{
+		conn:           conn,
+		reader:         reader,
+		maxMessageSize: maxMessageSize,
+		done:           make(chan struct{}),
+	}
+	self.pingHandler = func(data string) error {
+		err := self.WriteControl(WS_PONG, []byte(data), time.Now().Add(time.Second))
+		if err == ErrWSClosed {
+			return nil
+		}
+		return err
+	}
+	self.pongHandler = func(string) error { return nil }
+	return self
+}
+
+func (self *WSConn) keepalive(interval time.Duration) {
+	self.readTimeout = interval * 2
+	self.conn.SetReadDeadline(time.Now().Add(self.readTimeout))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if self.WriteControl(WS_PING, nil, time.Now().Add(interval)) != nil {
+					return
+				}
+			case <-self.done:
+				return
+			}
+		}
+	}()
+}
+
+func (self *WSConn) RemoteAddr() net.Addr {
+	return self.conn.RemoteAddr()
+}
+
+func (self *WSConn) SetReadDeadline(t time.Time) error {
+	return self.conn.SetReadDeadline(t)
+}
+
+func (self *WSConn) SetWriteDeadline(t time.Time) error {
+	return self.conn.SetWriteDeadline(t)
+}
+
+func (self *WSConn) SetPingHandler(handler func(data string) error) {
+	self.pingHandler = handler
+}
+
+func (self *WSConn) SetPongHandler(handler func(data string) error) {
+	self.pongHandler = handler
+}
+
+func (self *WSConn) ReadMessage() (messageType int, data []byte, err error) {
+	messageType = -1
+	for {
+		fin, opcode, payload, err := self.readFrame(int64(len(data)))
+		if err != nil {
+			return -1, nil, err
+		}
+		switch opcode {
+		case WS_PING:
+			if err = self.pingHandler(string(payload)); err != nil {
+				return -1, nil, err
+			}
+			continue
+		case WS_PONG:
+			if err = self.pongHandler(string(payload)); err != nil {
+				return -1, nil, err
+			}
+			continue
+		case WS_CLOSE:
+			return -1, nil, self.handleClose(payload)
+		case WS_TEXT, WS_BINARY:
+			if messageType != -1 {
+				return -1, nil, self.fail(WS_CLOSE_PROTOCOL_ERROR, "unexpected data frame")
+			}
+			messageType = opcode
+			data = payload
+		case WS_CONTINUATION:
+			if messageType == -1 {
+				return -1, nil, self.fail(WS_CLOSE_PROTOCOL_ERROR, "unexpected continuation frame")
+			}
+			data = append(data, payload...)
+		default:
+			return -1, nil, self.fail(WS_CLOSE_PROTOCOL_ERROR, "unknown opcode")
+		}
+		if !fin {
+			continue
+		}
+		if messageType == WS_TEXT && !utf8.Valid(data) {
+			return -1, nil, self.fail(WS_CLOSE_INVALID_PAYLOAD, "invalid utf8")
+		}
+		return messageType, data, nil
+	}
+}
+
+func (self *WSConn) readFrame(received int64) (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(self.reader, head[:]); err != nil {
+		return
+	}
+	if self.readTimeout > 0 {
+		self.conn.SetReadDeadline(time.Now().Add(self.readTimeout))
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0f)
+	if head[0]&0x70 != 0 {
+		err = self.fail(WS_CLOSE_PROTOCOL_ERROR, "reserved bits set")
+		return
+	}
+	if head[1]&0x80 == 0 {
+		err = self.fail(WS_CLOSE_PROTOCOL_ERROR, "frame not masked")
+		return
+	}
+	length := int64(head[1] & 0x7f)
+	isControl := opcode >= WS_CLOSE
+	if isControl && (!fin || length > 125) {
+		err = self.fail(WS_CLOSE_PROTOCOL_ERROR, "invalid control frame")
+		return
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(self.reader, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(self.reader, ext[:]); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint64(ext[:])
+		if size>>63 != 0 {
+			err = self.fail(WS_CLOSE_PROTOCOL_ERROR, "invalid frame length")
+			return
+		}
+		length = int64(size)
+	}
+	if !isControl && (length > self.maxMessageSize-received || length > math.MaxInt) {
+		err = self.fail(WS_CLOSE_TOO_LARGE, "message too large")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(self.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(self.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (self *WSConn) handleClose(payload []byte) error {
+	code := WS_CLOSE_NO_STATUS
+	text := ""
+	if len(payload) == 1 {
+		return self.fail(WS_CLOSE_PROTOCOL_ERROR, "invalid close frame")
+	}
+	if len(payload) >= 2 {
+		code = int(binary.BigEndian.Uint16(payload))
+		text = string(payload[2:])
+		if !validCloseCode(code) || !utf8.ValidString(text) {
+			return self.fail(WS_CLOSE_PROTOCOL_ERROR, "invalid close frame")
+		}
+	}
+	reply := WS_CLOSE_NORMAL
+	if code != WS_CLOSE_NO_STATUS {
+		reply = code
+	}
+	self.writeClose(reply, "")
+	return &WSCloseError{code, text}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1011:
+		return false
+	}
+	return code != 1004 && code != WS_CLOSE_NO_STATUS && code != WS_CLOSE_ABNORMAL
+}
+
+// 发送关闭帧并返回对应的错误
+func (self *WSConn) fail(code int, text string) error {
+	self.writeClose(code, text)
+	return &WSCloseError{code, text}
+}
+
+func (self *WSConn) writeClose(code int, text string) error {
+	payload := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], text)
+	return self.WriteControl(WS_CLOSE, payload, time.Now().Add(time.Second))
+}
+
+func (self *WSConn) writeFrame(fin bool, opcode int, payload []byte) error {
+	var head [10]byte
+	head[0] = byte(opcode)
+	if fin {
+		head[0] |= 0x80
+	}
+	n := 2
+	length := len(payload)
+	switch {
+	case length <= 125:
+		head[1] = byte(length)
+	case length <= 0xffff:
+		head[1] = 126
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+		n += 2
+	default:
+		head[1] = 127
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+		n += 8
+	}
+	if _, err := self.conn.Write(head[:n]); err != nil {
+		return err
+	}
+	_, err := self.conn.Write(payload)
+	return err
+}
+
+func (self *WSConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != WS_TEXT && messageType != WS_BINARY {
+		return self.WriteControl(messageType, data, time.Time{})
+	}
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+	if self.closeSent {
+		return ErrWSClosed
+	}
+	size := self.writeFragmentSize
+	if size <= 0 || len(data) <= size {
+		return self.writeFrame(true, messageType, data)
+	}
+	opcode := messageType
+	for len(data) > size {
+		if err := self.writeFrame(false, opcode, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+		opcode = WS_CONTINUATION
+	}
+	return self.writeFrame(true, opcode, data)
+}
+
+func (self *WSConn) WriteText(text string) error {
+	return self.WriteMessage(WS_TEXT, []byte(text))
+}
+
+func (self *WSConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if messageType != WS_CLOSE && messageType != WS_PING && messageType != WS_PONG {
+		return errors.New("invalid control message type")
+	}
+	if len(data) > 125 {
+		return errors.New("control message too large")
+	}
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+	if self.closeSent {
+		return ErrWSClosed
+	}
+	if messageType == WS_CLOSE {
+		self.closeSent = true
+	}
+	self.conn.SetWriteDeadline(deadline)
+	defer self.conn.SetWriteDeadline(time.Time{})
+	return self.writeFrame(true, messageType, data)
+}
+
+func (self *WSConn) Ping(data []byte) error {
+	return self.WriteControl(WS_PING, data, time.Now().Add(time.Second))
+}
+
+// 发起关闭握手, 等待客户端回应关闭帧后断开连接, 不能与ReadMessage并发调用
+func (self *WSConn) Close(code int, text string) error {
+	err := self.writeClose(code, text)
+	if err == nil {
+		self.conn.SetReadDeadline(time.Now().Add(DEFAULT_WS_CLOSE_TIMEOUT))
+		for {
+			_, _, rerr := self.ReadMessage()
+			if rerr != nil {
+				break
+			}
+		}
+	}
+	self.release()
+	if err == ErrWSClosed {
+		return nil
+	}
+	return err
+}
+
+func (self *WSConn) release() {
+	self.closeOnce.Do(func() {
+		self.writeClose(WS_CLOSE_NORMAL, "")
+		close(self.done)
+		self.conn.Close()
+	})
+}
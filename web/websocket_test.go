@@ -0,0 +1,226 @@
+//Copyright (C) Mr.Pungle
+
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, ts *httptest.Server, path string) (*wsTestClient, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: example.com\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n" +
+		"Origin: http://example.com\r\nCookie: sid=abc\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Error("Sec-WebSocket-Accept error:", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &wsTestClient{conn, reader}, resp
+}
+
+func (self *wsTestClient) writeFrame(fin bool, opcode int, payload []byte) {
+	head := []byte{byte(opcode), 0x80}
+	if fin {
+		head[0] |= 0x80
+	}
+	if len(payload) <= 125 {
+		head[1] |= byte(len(payload))
+	} else {
+		head[1] |= 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	self.conn.Write(append(append(head, mask...), masked...))
+}
+
+func (self *wsTestClient) readFrame() (int, []byte) {
+	var head [2]byte
+	if _, err := self.reader.Read(head[:1]); err != nil {
+		return -1, nil
+	}
+	self.reader.Read(head[1:])
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		self.reader.Read(ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	for n := 0; n < length; {
+		m, err := self.reader.Read(payload[n:])
+		if err != nil {
+			return -1, nil
+		}
+		n += m
+	}
+	return int(head[0] & 0x0f), payload
+}
+
+func TestWebSocket(t *testing.T) {
+	server := newTestServer()
+	server.WebSocket("^/ws/{room: [a-z]+}$", func(conn *WSConn, ctx *HttpContext) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, append([]byte(ctx.GetVar("room")+":"), data...))
+		}
+	}, WSMaxMessageSize(200), WSBeforeUpgrade(func(ctx *HttpContext) bool {
+		cookie, _ := ctx.Request.Cookie("sid")
+		ctx.Response.Header().Set("X-Session", cookie.Value)
+		return true
+	}))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, resp := dialWebSocket(t, ts, "/ws/chat")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("X-Session") != "abc" {
+		t.Fatal("Upgrade response error:", resp.StatusCode, resp.Header)
+	}
+
+	client.writeFrame(false, WS_TEXT, []byte("hel"))
+	client.writeFrame(true, WS_PING, []byte("p"))
+	client.writeFrame(true, WS_CONTINUATION, []byte("lo"))
+	if opcode, data := client.readFrame(); opcode != WS_PONG || string(data) != "p" {
+		t.Error("Pong error:", opcode, string(data))
+	}
+	if opcode, data := client.readFrame(); opcode != WS_TEXT || string(data) != "chat:hello" {
+		t.Error("Fragmented message error:", opcode, string(data))
+	}
+
+	client.writeFrame(true, WS_BINARY, make([]byte, 201))
+	opcode, data := client.readFrame()
+	if opcode != WS_CLOSE || binary.BigEndian.Uint16(data) != WS_CLOSE_TOO_LARGE {
+		t.Error("Message size limit error:", opcode, data)
+	}
+}
+
+func TestWebSocketCloseHandshake(t *testing.T) {
+	server := newTestServer()
+	closed := make(chan error, 1)
+	server.WebSocket("/ws", func(conn *WSConn, ctx *HttpContext) {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client, _ := dialWebSocket(t, ts, "/ws")
+	client.writeFrame(true, WS_CLOSE, []byte{0x03, 0xe8, 'b', 'y', 'e'})
+	opcode, data := client.readFrame()
+	if opcode != WS_CLOSE || binary.BigEndian.Uint16(data) != WS_CLOSE_NORMAL {
+		t.Error("Close reply error:", opcode, data)
+	}
+	err := <-closed
+	if closeErr, ok := err.(*WSCloseError); !ok || closeErr.Code != WS_CLOSE_NORMAL || closeErr.Text != "bye" {
+		t.Error("Close error:", err)
+	}
+	if opcode, _ = client.readFrame(); opcode != -1 {
+		t.Error("Connection should be closed after close handshake")
+	}
+}
+
+func TestWebSocketBadHandshake(t *testing.T) {
+	server := newTestServer()
+	server.WebSocket("/ws", func(conn *WSConn, ctx *HttpContext) {
+		t.Error("Handler should not be called")
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest("GET", "/ws", nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Error("Bad handshake status error:", resp.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "http://evil.com")
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Error("Cross origin status error:", resp.Code)
+	}
+}
+
+func TestWebSocketSession(t *testing.T) {
+	driver := NewMemorySessionDriver(MemoryGCInterval(0))
+	server := newSessionServer(driver)
+	limits := make(chan int64, 1)
+	server.WebSocket("/ws", func(conn *WSConn, ctx *HttpContext) {
+		limits <- conn.maxMessageSize
+	}, WSMaxMessageSize(-1), WSBeforeUpgrade(func(ctx *HttpContext) bool {
+		ctx.NewSession().Set("user", "dawn")
+		return true
+	}))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	_, resp := dialWebSocket(t, ts, "/ws")
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "sid" {
+			cookie = c
+		}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || cookie == nil {
+		t.Fatal("Session cookie should be sent with upgrade response:", resp.Header)
+	}
+	sid, _ := server.sessionCtx.parseCookieValue(cookie.Value)
+	if data, _ := driver.Get(sid); data == nil {
+		t.Error("Session should be saved before upgrade")
+	}
+	if limit := <-limits; limit != DEFAULT_WS_MAX_MESSAGE_SIZE {
+		t.Error("Non-positive size should use default limit:", limit)
+	}
+}
+
+func TestWebSocketProxyOrigin(t *testing.T) {
+	server := newTestServer()
+	server.SetTrustedProxies("10.0.0.0/8")
+	server.WebSocket("/ws", func(conn *WSConn, ctx *HttpContext) {})
+	req := httptest.NewRequest("GET", "http://internal:8080/ws", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://www.example.com")
+	req.Header.Set("X-Forwarded-For", "2.2.2.2")
+	req.Header.Set("X-Forwarded-Host", "www.example.com")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	// 通过了Origin检查, ResponseRecorder不支持Hijack
+	if resp.Code != http.StatusInternalServerError {
+		t.Error("Origin should be compared with forwarded host:", resp.Code)
+	}
+}